@@ -22,6 +22,7 @@ import (
 	"fmt"
 
 	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
 )
 
 // Iterator for disassembled EVM instructions
@@ -119,14 +120,32 @@ func PrintDisassembled(code string) error {
 
 // Return all disassembled EVM instructions in human-readable format.
 func Disassemble(script []byte) ([]string, error) {
+	return disassemble(script, nil)
+}
+
+// Return all disassembled EVM instructions in human-readable format, marking
+// the instructions which are not available under the given chain rules.
+//
+// Note, this doesn't affect how the code is disassembled: bytes following an
+// unavailable PUSH instruction are still considered to be its argument.
+func DisassembleForRules(script []byte, rules params.Rules) ([]string, error) {
+	jt := vm.LookupInstructionSet(rules)
+	return disassemble(script, &jt)
+}
+
+func disassemble(script []byte, jt *vm.JumpTable) ([]string, error) {
 	instrs := make([]string, 0)
 
 	it := NewInstructionIterator(script)
 	for it.Next() {
+		var invalid string
+		if jt != nil && !jt.Valid(it.Op()) {
+			invalid = " (invalid)"
+		}
 		if it.Arg() != nil && 0 < len(it.Arg()) {
-			instrs = append(instrs, fmt.Sprintf("%05x: %v 0x%x\n", it.PC(), it.Op(), it.Arg()))
+			instrs = append(instrs, fmt.Sprintf("%05x: %v 0x%x%s\n", it.PC(), it.Op(), it.Arg(), invalid))
 		} else {
-			instrs = append(instrs, fmt.Sprintf("%05x: %v\n", it.PC(), it.Op()))
+			instrs = append(instrs, fmt.Sprintf("%05x: %v%s\n", it.PC(), it.Op(), invalid))
 		}
 	}
 	if err := it.Error(); err != nil {
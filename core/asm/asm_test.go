@@ -17,9 +17,12 @@
 package asm
 
 import (
+	"strings"
 	"testing"
 
 	"encoding/hex"
+
+	"github.com/ethereum/go-ethereum/params"
 )
 
 // Tests disassembling the instructions for valid evm code
@@ -72,3 +75,28 @@ func TestInstructionIteratorEmpty(t *testing.T) {
 		t.Errorf("Expected 0, but got %v instead.", cnt)
 	}
 }
+
+// Tests disassembling the instructions against the rules of a fork
+func TestDisassembleForRules(t *testing.T) {
+	// PUSH1 0x01, PUSH1 0x02, SHL, 0x5f
+	script, _ := hex.DecodeString("600160021b5f")
+
+	byzantium, err := DisassembleForRules(script, params.Rules{IsHomestead: true, IsEIP150: true, IsEIP155: true, IsEIP158: true, IsByzantium: true})
+	if err != nil {
+		t.Fatalf("Failed to disassemble: %v", err)
+	}
+	constantinople, err := DisassembleForRules(script, params.Rules{IsHomestead: true, IsEIP150: true, IsEIP155: true, IsEIP158: true, IsByzantium: true, IsConstantinople: true})
+	if err != nil {
+		t.Fatalf("Failed to disassemble: %v", err)
+	}
+	for i, want := range []bool{false, false, true, true} {
+		if have := strings.HasSuffix(byzantium[i], " (invalid)\n"); have != want {
+			t.Errorf("byzantium instruction %d: invalid mismatch: have %v, want %v", i, have, want)
+		}
+	}
+	for i, want := range []bool{false, false, false, true} {
+		if have := strings.HasSuffix(constantinople[i], " (invalid)\n"); have != want {
+			t.Errorf("constantinople instruction %d: invalid mismatch: have %v, want %v", i, have, want)
+		}
+	}
+}
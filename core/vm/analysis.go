@@ -16,6 +16,20 @@
 
 package vm
 
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// minStackCapacityHint is the lower bound of the stack capacity hinted by the
+// code analysis, avoiding reallocations for the few items any code pushes.
+const minStackCapacityHint = 16
+
 // bitvec is a bit vector which maps bytes in a program.
 // An unset bit means the byte is an opcode, a set bit means
 // it's data (i.e. argument of PUSHxx).
@@ -29,6 +43,17 @@ func (bits *bitvec) set8(pos uint64) {
 	(*bits)[pos/8+1] |= ^(0xFF >> (pos % 8))
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (bits bitvec) MarshalBinary() ([]byte, error) {
+	return common.CopyBytes(bits), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (bits *bitvec) UnmarshalBinary(data []byte) error {
+	*bits = common.CopyBytes(data)
+	return nil
+}
+
 // codeSegment checks if the position is in a code segment.
 func (bits *bitvec) codeSegment(pos uint64) bool {
 	return ((*bits)[pos/8] & (0x80 >> (pos % 8))) == 0
@@ -60,3 +85,188 @@ func codeBitmap(code []byte) bitvec {
 	}
 	return bits
 }
+
+// errInconsistentAnalysis is returned if an analysis doesn't match the code it
+// is claimed to belong to.
+var errInconsistentAnalysis = errors.New("jumpdest analysis inconsistent with code")
+
+// JumpdestAnalysis is the result of the JUMPDEST analysis of a contract code,
+// which can be persisted and reloaded to avoid repeatedly analysing the same
+// code.
+type JumpdestAnalysis struct {
+	codeHash common.Hash
+	bits     bitvec
+}
+
+// AnalyzeJumpdests runs the JUMPDEST analysis of code.
+func AnalyzeJumpdests(code []byte) *JumpdestAnalysis {
+	return &JumpdestAnalysis{
+		codeHash: crypto.Keccak256Hash(code),
+		bits:     codeBitmap(code),
+	}
+}
+
+// LoadJumpdestAnalysis decodes a persisted analysis, verifying that it belongs
+// to the given code and is consistent with it. A persisted analysis is untrusted
+// input, executing code with an inconsistent one could read past its end.
+func LoadJumpdestAnalysis(data []byte, code []byte) (*JumpdestAnalysis, error) {
+	a := new(JumpdestAnalysis)
+	if err := a.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	if codeHash := crypto.Keccak256Hash(code); a.codeHash != codeHash {
+		return nil, fmt.Errorf("jumpdest analysis code hash mismatch: have %x, want %x", a.codeHash, codeHash)
+	}
+	if !a.consistent(code) {
+		return nil, errInconsistentAnalysis
+	}
+	return a, nil
+}
+
+// CodeHash returns the hash of the analysed code.
+func (a *JumpdestAnalysis) CodeHash() common.Hash {
+	return a.codeHash
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler, encoding the analysis
+// along with the hash of the analysed code.
+func (a *JumpdestAnalysis) MarshalBinary() ([]byte, error) {
+	bits, err := a.bits.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append(a.codeHash.Bytes(), bits...), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (a *JumpdestAnalysis) UnmarshalBinary(data []byte) error {
+	if len(data) < common.HashLength {
+		return fmt.Errorf("jumpdest analysis too short: %d bytes", len(data))
+	}
+	a.codeHash = common.BytesToHash(data[:common.HashLength])
+	return a.bits.UnmarshalBinary(data[common.HashLength:])
+}
+
+// Verify checks whether the analysis is consistent with code, i.e. belongs to
+// it and classifies every byte of it the same way as analysing the code would.
+// The check walks the code without allocating a fresh analysis.
+//
+// Note, this is merely a consistency check of an untrusted analysis, it doesn't
+// provide any cryptographic proof of the analysis' correctness.
+func (a *JumpdestAnalysis) Verify(code []byte) bool {
+	return a.codeHash == crypto.Keccak256Hash(code) && a.consistent(code)
+}
+
+// consistent checks whether the analysis classifies every byte of code the same
+// way as analysing the code would, disregarding the code hash.
+func (a *JumpdestAnalysis) consistent(code []byte) bool {
+	if uint64(len(a.bits)) < uint64(len(code))/8+1 {
+		return false
+	}
+	for pc := uint64(0); pc < uint64(len(code)); {
+		op := OpCode(code[pc])
+		if !a.bits.codeSegment(pc) {
+			return false
+		}
+		pc++
+		if op >= PUSH1 && op <= PUSH32 {
+			for end := pc + uint64(op-PUSH1+1); pc < end && pc < uint64(len(code)); pc++ {
+				if a.bits.codeSegment(pc) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// JumpdestCache is a cache of JUMPDEST analyses keyed by code hash, which can be
+// shared by any number of EVMs to avoid analysing the same code over and over.
+// Unlike the analyses cached by contracts, which only live for a single top
+// level call, the cache may live as long as the process. It is safe for
+// concurrent use.
+type JumpdestCache struct {
+	cache *lru.Cache
+}
+
+// NewJumpdestCache creates a cache holding up to size analyses, evicting the
+// least recently used ones beyond that.
+func NewJumpdestCache(size int) *JumpdestCache {
+	cache, _ := lru.New(size)
+	return &JumpdestCache{cache: cache}
+}
+
+// Warm analyses the given codes ahead of their execution. Codes already cached
+// aren't analysed again.
+func (c *JumpdestCache) Warm(codes [][]byte) {
+	for _, code := range codes {
+		if len(code) > 0 {
+			c.analysis(crypto.Keccak256Hash(code), code)
+		}
+	}
+}
+
+// Add inserts a previously computed analysis of code into the cache, e.g. one
+// loaded from disk. Analyses inconsistent with the code are rejected, as they'd
+// be trusted by every EVM sharing the cache.
+func (c *JumpdestCache) Add(a *JumpdestAnalysis, code []byte) error {
+	if !a.Verify(code) {
+		return errInconsistentAnalysis
+	}
+	c.cache.ContainsOrAdd(a.codeHash, a.bits)
+	return nil
+}
+
+// Len returns the number of cached analyses.
+func (c *JumpdestCache) Len() int {
+	return c.cache.Len()
+}
+
+// analysis returns the analysis of code with the given hash, analysing and
+// caching it if it isn't cached yet.
+func (c *JumpdestCache) analysis(codeHash common.Hash, code []byte) bitvec {
+	if bits, ok := c.cache.Get(codeHash); ok {
+		return bits.(bitvec)
+	}
+	bits := codeBitmap(code)
+	c.cache.ContainsOrAdd(codeHash, bits)
+	return bits
+}
+
+// stackCapacityHint estimates the stack capacity needed to execute code from the
+// number of PUSH operations it contains, bounded by the stack limit. The hint is
+// purely heuristic, as loops may push arbitrarily many items.
+func stackCapacityHint(code []byte) int {
+	pushes := 0
+	for pc := uint64(0); pc < uint64(len(code)); pc++ {
+		if op := OpCode(code[pc]); op >= PUSH1 && op <= PUSH32 {
+			pc += uint64(op - PUSH1 + 1)
+			pushes++
+		}
+	}
+	switch {
+	case pushes < minStackCapacityHint:
+		return minStackCapacityHint
+	case pushes > int(params.StackLimit):
+		return int(params.StackLimit)
+	default:
+		return pushes
+	}
+}
+
+// CodeFingerprint hashes the opcode structure of code, disregarding the values
+// pushed onto the stack. Contracts differing only in embedded constants, e.g.
+// immutable addresses, share the same fingerprint.
+//
+// PUSH immediates are dropped, as the PUSH opcode itself marks their length.
+func CodeFingerprint(code []byte) common.Hash {
+	ops := make([]byte, 0, len(code))
+	for pc := uint64(0); pc < uint64(len(code)); pc++ {
+		op := OpCode(code[pc])
+		if op >= PUSH1 && op <= PUSH32 {
+			pc += uint64(op - PUSH1 + 1)
+		}
+		ops = append(ops, byte(op))
+	}
+	return crypto.Keccak256Hash(ops)
+}
@@ -17,9 +17,13 @@
 package vm
 
 import (
+	"bytes"
+	"sync"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
 )
 
 func TestJumpDestAnalysis(t *testing.T) {
@@ -73,3 +77,157 @@ func BenchmarkJumpdestHashing_1200k(bench *testing.B) {
 	}
 	bench.StopTimer()
 }
+
+func TestStackCapacityHint(t *testing.T) {
+	push := func(n int) []byte {
+		code := make([]byte, 0, 2*n)
+		for i := 0; i < n; i++ {
+			code = append(code, byte(PUSH1), byte(PUSH1))
+		}
+		return code
+	}
+	tests := []struct {
+		code []byte
+		hint int
+	}{
+		{nil, minStackCapacityHint},
+		{push(3), minStackCapacityHint},
+		{push(100), 100},
+		// PUSH immediates must not be counted as pushes
+		{append([]byte{byte(PUSH32)}, push(40)...), 25},
+		{push(2000), int(params.StackLimit)},
+	}
+	for i, test := range tests {
+		if hint := stackCapacityHint(test.code); hint != test.hint {
+			t.Errorf("test %d: hint mismatch: have %d, want %d", i, hint, test.hint)
+		}
+	}
+}
+
+func TestCodeFingerprint(t *testing.T) {
+	var (
+		// PUSH20 <addr> BALANCE PUSH1 <x> MSTORE
+		a = []byte{byte(PUSH20), 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, byte(BALANCE), byte(PUSH1), 0x00, byte(MSTORE)}
+		b = []byte{byte(PUSH20), 0xff, 0xfe, 0xfd, 0xfc, 0xfb, 0xfa, 0xf9, 0xf8, 0xf7, 0xf6, 0xf5, 0xf4, 0xf3, 0xf2, 0xf1, 0xf0, 0xef, 0xee, 0xed, 0xec, byte(BALANCE), byte(PUSH1), 0x20, byte(MSTORE)}
+		// Same constants, but different structure
+		c = []byte{byte(PUSH20), 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, byte(EXTCODESIZE), byte(PUSH1), 0x00, byte(MSTORE)}
+		// Same opcodes, but a differently sized immediate
+		d = []byte{byte(PUSH20), 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, byte(BALANCE), byte(PUSH2), 0x00, 0x00, byte(MSTORE)}
+	)
+	if CodeFingerprint(a) != CodeFingerprint(b) {
+		t.Errorf("structurally identical code fingerprint mismatch")
+	}
+	if CodeFingerprint(a) == CodeFingerprint(c) {
+		t.Errorf("structurally different opcodes share fingerprint")
+	}
+	if CodeFingerprint(a) == CodeFingerprint(d) {
+		t.Errorf("structurally different immediates share fingerprint")
+	}
+}
+
+func TestJumpdestAnalysisEncoding(t *testing.T) {
+	code := []byte{byte(PUSH1), byte(JUMPDEST), byte(JUMPDEST), byte(PUSH32)}
+	analysis := AnalyzeJumpdests(code)
+
+	blob, err := analysis.MarshalBinary()
+	if err != nil {
+		t.Fatalf("failed to encode analysis: %v", err)
+	}
+	loaded, err := LoadJumpdestAnalysis(blob, code)
+	if err != nil {
+		t.Fatalf("failed to load analysis: %v", err)
+	}
+	if loaded.CodeHash() != analysis.CodeHash() {
+		t.Errorf("code hash mismatch: have %x, want %x", loaded.CodeHash(), analysis.CodeHash())
+	}
+	if !bytes.Equal(loaded.bits, codeBitmap(code)) {
+		t.Errorf("bitvec mismatch: have %x, want %x", loaded.bits, codeBitmap(code))
+	}
+	// Analyses of other code must be rejected
+	if _, err := LoadJumpdestAnalysis(blob, []byte{byte(STOP)}); err == nil {
+		t.Errorf("loaded analysis of mismatching code")
+	}
+	if _, err := LoadJumpdestAnalysis(blob[:10], code); err == nil {
+		t.Errorf("loaded truncated analysis")
+	}
+	// Analyses with a truncated bitvec must be rejected too, as they would make
+	// the jump destination checks read past their end
+	if _, err := LoadJumpdestAnalysis(blob[:common.HashLength], code); err == nil {
+		t.Errorf("loaded analysis with truncated bitvec")
+	}
+}
+
+func TestJumpdestAnalysisVerify(t *testing.T) {
+	code := []byte{byte(PUSH1), byte(JUMPDEST), byte(JUMPDEST), byte(PUSH2), 0x01, 0x02, byte(JUMPDEST)}
+
+	if !AnalyzeJumpdests(code).Verify(code) {
+		t.Errorf("correct analysis rejected")
+	}
+	// Claiming the PUSH1 argument is a jump destination must be detected
+	tampered := AnalyzeJumpdests(code)
+	tampered.bits[0] &^= 0x40
+	if tampered.Verify(code) {
+		t.Errorf("tampered analysis accepted")
+	}
+	// Analyses of other code must be rejected, even if the bits match
+	other := append(common.CopyBytes(code[:6]), byte(STOP))
+	if AnalyzeJumpdests(other).Verify(code) {
+		t.Errorf("analysis of other code accepted")
+	}
+	// Truncated analyses must be rejected without panicking
+	truncated := AnalyzeJumpdests(code)
+	truncated.bits = truncated.bits[:0]
+	if truncated.Verify(code) {
+		t.Errorf("truncated analysis accepted")
+	}
+}
+
+func TestJumpdestCache(t *testing.T) {
+	codes := [][]byte{
+		{byte(PUSH1), byte(JUMPDEST), byte(JUMPDEST)},
+		{byte(PUSH2), byte(JUMPDEST), byte(JUMPDEST), byte(JUMPDEST)},
+		{byte(JUMPDEST), byte(STOP)},
+	}
+	cache := NewJumpdestCache(16)
+
+	// Warm the cache concurrently, repeatedly with the same codes
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.Warm(codes)
+		}()
+	}
+	wg.Wait()
+	cache.Warm(append(codes, nil))
+
+	if have := cache.Len(); have != len(codes) {
+		t.Fatalf("cached analyses mismatch: have %d, want %d", have, len(codes))
+	}
+	for i, code := range codes {
+		if have := cache.analysis(crypto.Keccak256Hash(code), code); !bytes.Equal(have, codeBitmap(code)) {
+			t.Errorf("code %d: bitvec mismatch: have %x, want %x", i, have, codeBitmap(code))
+		}
+	}
+	// Loaded analyses are only inserted if consistent with the code
+	code := []byte{byte(PUSH3), byte(JUMPDEST), byte(JUMPDEST), byte(JUMPDEST)}
+
+	truncated := AnalyzeJumpdests(code)
+	truncated.bits = truncated.bits[:0]
+	if err := cache.Add(truncated, code); err == nil {
+		t.Errorf("truncated analysis inserted")
+	}
+	if err := cache.Add(AnalyzeJumpdests(code), []byte{byte(STOP)}); err == nil {
+		t.Errorf("analysis of other code inserted")
+	}
+	if have := cache.Len(); have != len(codes) {
+		t.Errorf("cached analyses mismatch after rejecting: have %d, want %d", have, len(codes))
+	}
+	if err := cache.Add(AnalyzeJumpdests(code), code); err != nil {
+		t.Errorf("failed to insert analysis: %v", err)
+	}
+	if have := cache.Len(); have != len(codes)+1 {
+		t.Errorf("cached analyses mismatch after adding: have %d, want %d", have, len(codes)+1)
+	}
+}
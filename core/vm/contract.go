@@ -51,6 +51,7 @@ type Contract struct {
 
 	jumpdests map[common.Hash]bitvec // Aggregated result of JUMPDEST analysis.
 	analysis  bitvec                 // Locally cached result of JUMPDEST analysis
+	cache     *JumpdestCache         // Analyses shared beyond the top level call, if any
 
 	Code     []byte
 	CodeHash common.Hash
@@ -97,9 +98,14 @@ func (c *Contract) validJumpdest(dest *big.Int) bool {
 		// Does parent context have the analysis?
 		analysis, exist := c.jumpdests[c.CodeHash]
 		if !exist {
-			// Do the analysis and save in parent context
-			// We do not need to store it in c.analysis
-			analysis = codeBitmap(c.Code)
+			// Do the analysis (or retrieve it from the shared cache)
+			// and save in parent context. We do not need to store it
+			// in c.analysis
+			if c.cache != nil {
+				analysis = c.cache.analysis(c.CodeHash, c.Code)
+			} else {
+				analysis = codeBitmap(c.Code)
+			}
 			c.jumpdests[c.CodeHash] = analysis
 		}
 		return analysis.codeSegment(udest)
@@ -16,7 +16,10 @@
 
 package vm
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // List execution errors
 var (
@@ -27,4 +30,43 @@ var (
 	ErrInsufficientBalance      = errors.New("insufficient balance for transfer")
 	ErrContractAddressCollision = errors.New("contract address collision")
 	ErrNoCompatibleInterpreter  = errors.New("no compatible interpreter")
+	ErrExecutionReverted        = errors.New("evm: execution reverted")
+	ErrReturnDataLimitExceeded  = errors.New("return data size limit exceeded")
+	ErrSubcallLimitExceeded     = errors.New("sub-call limit exceeded")
+	ErrWriteProtection          = errors.New("evm: write protection")
+	ErrReturnDataOutOfBounds    = errors.New("evm: return data out of bounds")
+	ErrMaxCodeSizeExceeded      = errors.New("evm: max code size exceeded")
+	ErrInvalidJump              = errors.New("evm: invalid jump destination")
+	ErrGasUintOverflow          = errors.New("gas uint64 overflow")
 )
+
+// ErrStackUnderflow wraps an evm error when the items on the stack are less
+// than the minimal requirement.
+type ErrStackUnderflow struct {
+	StackLen int
+	Required int
+}
+
+func (e *ErrStackUnderflow) Error() string {
+	return fmt.Sprintf("stack underflow (%d <=> %d)", e.StackLen, e.Required)
+}
+
+// ErrStackOverflow wraps an evm error when the items on the stack exceed the
+// maximum allowance.
+type ErrStackOverflow struct {
+	StackLen int
+	Limit    int
+}
+
+func (e *ErrStackOverflow) Error() string {
+	return fmt.Sprintf("stack limit reached %d (%d)", e.StackLen, e.Limit)
+}
+
+// ErrInvalidOpCode wraps an evm error when an invalid opcode is encountered.
+type ErrInvalidOpCode struct {
+	OpCode OpCode
+}
+
+func (e *ErrInvalidOpCode) Error() string {
+	return fmt.Sprintf("invalid opcode 0x%x", int(e.OpCode))
+}
@@ -128,6 +128,11 @@ type EVM struct {
 	// available gas is calculated in gasCall* according to the 63/64 rule and later
 	// applied in opCall*.
 	callGasTemp uint64
+	// subcalls counts the CALL- and CREATE-family operations executed, if
+	// their number is capped by the configuration.
+	subcalls int
+	// failure holds the error the top level call or creation aborted with.
+	failure error
 }
 
 // NewEVM returns a new EVM. The returned EVM is not thread safe and should
@@ -190,6 +195,9 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 	if evm.vmConfig.NoRecursion && evm.depth > 0 {
 		return nil, gas, nil
 	}
+	if evm.depth == 0 {
+		defer func() { evm.failure = err }()
+	}
 
 	// Fail if we're trying to execute above the call depth limit
 	if evm.depth > int(params.CallCreateDepth) {
@@ -199,6 +207,21 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 	if !evm.Context.CanTransfer(evm.StateDB, caller.Address(), value) {
 		return nil, gas, ErrInsufficientBalance
 	}
+	if output, ok := evm.vmConfig.Mocks[addr]; ok {
+		// Capture the mocked frame like any other, its code merely isn't run
+		if evm.vmConfig.Debug && evm.depth == 0 {
+			evm.vmConfig.Tracer.CaptureStart(caller.Address(), addr, false, input, gas, value)
+
+			defer func() {
+				evm.vmConfig.Tracer.CaptureEnd(ret, gas-leftOverGas, 0, err)
+			}()
+		}
+		if gas < evm.vmConfig.MockGas {
+			return nil, 0, ErrOutOfGas
+		}
+		evm.Transfer(evm.StateDB, caller.Address(), addr, value)
+		return common.CopyBytes(output), gas - evm.vmConfig.MockGas, nil
+	}
 
 	var (
 		to       = AccountRef(addr)
@@ -246,7 +269,7 @@ func (evm *EVM) Call(caller ContractRef, addr common.Address, input []byte, gas
 	// when we're in homestead this also counts for code storage gas errors.
 	if err != nil {
 		evm.StateDB.RevertToSnapshot(snapshot)
-		if err != errExecutionReverted {
+		if err != ErrExecutionReverted {
 			contract.UseGas(contract.Gas)
 		}
 	}
@@ -286,7 +309,7 @@ func (evm *EVM) CallCode(caller ContractRef, addr common.Address, input []byte,
 	ret, err = run(evm, contract, input, false)
 	if err != nil {
 		evm.StateDB.RevertToSnapshot(snapshot)
-		if err != errExecutionReverted {
+		if err != ErrExecutionReverted {
 			contract.UseGas(contract.Gas)
 		}
 	}
@@ -319,7 +342,7 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr common.Address, input []by
 	ret, err = run(evm, contract, input, false)
 	if err != nil {
 		evm.StateDB.RevertToSnapshot(snapshot)
-		if err != errExecutionReverted {
+		if err != ErrExecutionReverted {
 			contract.UseGas(contract.Gas)
 		}
 	}
@@ -338,6 +361,12 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 	if evm.depth > int(params.CallCreateDepth) {
 		return nil, gas, ErrDepth
 	}
+	if output, ok := evm.vmConfig.Mocks[addr]; ok {
+		if gas < evm.vmConfig.MockGas {
+			return nil, 0, ErrOutOfGas
+		}
+		return common.CopyBytes(output), gas - evm.vmConfig.MockGas, nil
+	}
 
 	var (
 		to       = AccountRef(addr)
@@ -360,7 +389,7 @@ func (evm *EVM) StaticCall(caller ContractRef, addr common.Address, input []byte
 	ret, err = run(evm, contract, input, true)
 	if err != nil {
 		evm.StateDB.RevertToSnapshot(snapshot)
-		if err != errExecutionReverted {
+		if err != ErrExecutionReverted {
 			contract.UseGas(contract.Gas)
 		}
 	}
@@ -441,13 +470,13 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 	// when we're in homestead this also counts for code storage gas errors.
 	if maxCodeSizeExceeded || (err != nil && (evm.chainRules.IsHomestead || err != ErrCodeStoreOutOfGas)) {
 		evm.StateDB.RevertToSnapshot(snapshot)
-		if err != errExecutionReverted {
+		if err != ErrExecutionReverted {
 			contract.UseGas(contract.Gas)
 		}
 	}
 	// Assign err if contract code size exceeds the max while the err is still empty.
 	if maxCodeSizeExceeded && err == nil {
-		err = errMaxCodeSizeExceeded
+		err = ErrMaxCodeSizeExceeded
 	}
 	if evm.vmConfig.Debug && evm.depth == 0 {
 		evm.vmConfig.Tracer.CaptureEnd(ret, gas-contract.Gas, time.Since(start), err)
@@ -458,7 +487,13 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 
 // Create creates a new contract using code as deployment code.
 func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *big.Int) (ret []byte, contractAddr common.Address, leftOverGas uint64, err error) {
+	if evm.depth == 0 {
+		defer func() { evm.failure = err }()
+	}
 	contractAddr = crypto.CreateAddress(caller.Address(), evm.StateDB.GetNonce(caller.Address()))
+	if evm.depth == 0 && evm.vmConfig.CreateAddress != nil {
+		contractAddr = *evm.vmConfig.CreateAddress
+	}
 	return evm.create(caller, &codeAndHash{code: code}, gas, value, contractAddr)
 }
 
@@ -467,10 +502,28 @@ func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *big.I
 // The different between Create2 with Create is Create2 uses sha3(0xff ++ msg.sender ++ salt ++ sha3(init_code))[12:]
 // instead of the usual sender-and-nonce-hash as the address where the contract is initialized at.
 func (evm *EVM) Create2(caller ContractRef, code []byte, gas uint64, endowment *big.Int, salt *big.Int) (ret []byte, contractAddr common.Address, leftOverGas uint64, err error) {
+	if evm.depth == 0 {
+		defer func() { evm.failure = err }()
+	}
 	codeAndHash := &codeAndHash{code: code}
 	contractAddr = crypto.CreateAddress2(caller.Address(), common.BigToHash(salt), codeAndHash.Hash().Bytes())
 	return evm.create(caller, codeAndHash, gas, endowment, contractAddr)
 }
 
+// CallGas returns the gas the CALL-family operation being executed forwards
+// to its callee, excluding the stipend of value transfers. It is only
+// meaningful to tracers capturing the state of such an operation.
+func (evm *EVM) CallGas() uint64 { return evm.callGasTemp }
+
+// Subcalls returns the number of CALL- and CREATE-family operations executed,
+// if their number is capped by Config.MaxSubcalls, including the one exceeding
+// the cap.
+func (evm *EVM) Subcalls() int { return evm.subcalls }
+
+// Failure returns the error the top level call or contract creation aborted
+// with, if any. It is available to callers only learning whether the execution
+// failed, e.g. from the state transition running it, without tracing it.
+func (evm *EVM) Failure() error { return evm.failure }
+
 // ChainConfig returns the environment's chain configuration
 func (evm *EVM) ChainConfig() *params.ChainConfig { return evm.chainConfig }
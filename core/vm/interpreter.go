@@ -17,7 +17,6 @@
 package vm
 
 import (
-	"fmt"
 	"hash"
 	"sync/atomic"
 
@@ -39,6 +38,36 @@ type Config struct {
 	EVMInterpreter   string // External EVM interpreter options
 
 	ExtraEips []int // Additional EIPS that are to be enabled
+
+	StackCapacityHint bool // Sizes stacks from the code analysis instead of the stack limit
+
+	// JumpdestCache, if set, is consulted for the JUMPDEST analyses of code not
+	// analysed yet within the top level call, and keeps the analyses beyond it.
+	JumpdestCache *JumpdestCache
+
+	// MaxReturnDataSize, if non-zero, caps the size of the data a call frame can
+	// return or revert with. The EVM itself doesn't cap return data, it is only
+	// bounded by the gas available for expanding memory, so the cap is merely a
+	// safeguard for hosts executing calls with huge gas allowances.
+	MaxReturnDataSize uint64
+
+	// MaxSubcalls, if non-zero, caps the number of CALL- and CREATE-family
+	// operations a top level call may execute across all of its frames, as a
+	// guard against pathological contracts. Exceeding it fails the top level
+	// call, regardless of the frames handling the failure of their callees.
+	// Unlike the call depth limit it isn't part of the consensus rules.
+	MaxSubcalls int
+
+	// CreateAddress, if set, overrides the address of the contract created by a
+	// top level CREATE, e.g. to simulate a deployment at a known address. It
+	// must not be used for consensus critical execution.
+	CreateAddress *common.Address
+
+	// Mocks, if set, short-circuits CALLs and STATICCALLs to the given accounts:
+	// their code isn't executed, the canned output is returned instead and only
+	// MockGas is charged. It must not be used for consensus critical execution.
+	Mocks   map[common.Address][]byte
+	MockGas uint64
 }
 
 // Interpreter is used to run Ethereum based contracts and will utilise the
@@ -83,6 +112,8 @@ type EVMInterpreter struct {
 
 	readOnly   bool   // Whether to throw on stateful modifications
 	returnData []byte // Last CALL's return data for subsequent reuse
+
+	stackHints map[common.Hash]int // Stack capacity hints of the executed code
 }
 
 // NewEVMInterpreter returns a new instance of the Interpreter.
@@ -91,21 +122,7 @@ func NewEVMInterpreter(evm *EVM, cfg Config) *EVMInterpreter {
 	// the jump table was initialised. If it was not
 	// we'll set the default jump table.
 	if !cfg.JumpTable[STOP].valid {
-		var jt JumpTable
-		switch {
-		case evm.chainRules.IsConstantinople:
-			jt = constantinopleInstructionSet
-		case evm.chainRules.IsByzantium:
-			jt = byzantiumInstructionSet
-		case evm.chainRules.IsEIP158:
-			jt = spuriousDragonInstructionSet
-		case evm.chainRules.IsEIP150:
-			jt = tangerineWhistleInstructionSet
-		case evm.chainRules.IsHomestead:
-			jt = homesteadInstructionSet
-		default:
-			jt = frontierInstructionSet
-		}
+		jt := LookupInstructionSet(evm.chainRules)
 		for i, eip := range cfg.ExtraEips {
 			if err := EnableEIP(eip, &jt); err != nil {
 				// Disable it, so caller can check if it's activated or not
@@ -127,7 +144,7 @@ func NewEVMInterpreter(evm *EVM, cfg Config) *EVMInterpreter {
 //
 // It's important to note that any errors returned by the interpreter should be
 // considered a revert-and-consume-all-gas operation except for
-// errExecutionReverted which means revert-and-keep-gas-left.
+// ErrExecutionReverted which means revert-and-keep-gas-left.
 func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (ret []byte, err error) {
 	if in.intPool == nil {
 		in.intPool = poolOfIntPools.get()
@@ -158,9 +175,9 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 	}
 
 	var (
-		op    OpCode        // current opcode
-		mem   = NewMemory() // bound memory
-		stack = newstack()  // local stack
+		op    OpCode                  // current opcode
+		mem   = NewMemory()           // bound memory
+		stack = in.newstack(contract) // local stack
 		// For optimisation reason we're using uint64 as the program counter.
 		// It's theoretically possible to go above 2^64. The YP defines the PC
 		// to be uint256. Practically much less so feasible.
@@ -173,6 +190,7 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		res     []byte // result of the opcode execution function
 	)
 	contract.Input = input
+	contract.cache = in.cfg.JumpdestCache
 
 	// Reclaim the stack as an int pool when the execution stops
 	defer func() { in.intPool.put(stack.data...) }()
@@ -203,13 +221,13 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		op = contract.GetOp(pc)
 		operation := in.cfg.JumpTable[op]
 		if !operation.valid {
-			return nil, fmt.Errorf("invalid opcode 0x%x", int(op))
+			return nil, &ErrInvalidOpCode{OpCode: op}
 		}
 		// Validate stack
 		if sLen := stack.len(); sLen < operation.minStack {
-			return nil, fmt.Errorf("stack underflow (%d <=> %d)", sLen, operation.minStack)
+			return nil, &ErrStackUnderflow{StackLen: sLen, Required: operation.minStack}
 		} else if sLen > operation.maxStack {
-			return nil, fmt.Errorf("stack limit reached %d (%d)", sLen, operation.maxStack)
+			return nil, &ErrStackOverflow{StackLen: sLen, Limit: operation.maxStack}
 		}
 		// If the operation is valid, enforce and write restrictions
 		if in.readOnly && in.evm.chainRules.IsByzantium {
@@ -219,7 +237,24 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 			// account to the others means the state is modified and should also
 			// return with an error.
 			if operation.writes || (op == CALL && stack.Back(2).Sign() != 0) {
-				return nil, errWriteProtection
+				return nil, ErrWriteProtection
+			}
+		}
+		// Refuse returning more data than allowed, before expanding memory for it
+		if in.cfg.MaxReturnDataSize > 0 && (op == RETURN || op == REVERT) {
+			if size := stack.Back(1); !size.IsUint64() || size.Uint64() > in.cfg.MaxReturnDataSize {
+				return nil, ErrReturnDataLimitExceeded
+			}
+		}
+		// Refuse issuing more sub-calls than allowed. Exceeding the cap aborts
+		// all frames, the callers of the offending one fail as they resume.
+		if in.cfg.MaxSubcalls > 0 {
+			switch op {
+			case CALL, CALLCODE, DELEGATECALL, STATICCALL, CREATE, CREATE2:
+				in.evm.subcalls++
+			}
+			if in.evm.subcalls > in.cfg.MaxSubcalls {
+				return nil, ErrSubcallLimitExceeded
 			}
 		}
 		// Static portion of gas
@@ -236,12 +271,12 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		if operation.memorySize != nil {
 			memSize, overflow := operation.memorySize(stack)
 			if overflow {
-				return nil, errGasUintOverflow
+				return nil, ErrGasUintOverflow
 			}
 			// memory is expanded in words of 32 bytes. Gas
 			// is also calculated in words.
 			if memorySize, overflow = math.SafeMul(toWordSize(memSize), 32); overflow {
-				return nil, errGasUintOverflow
+				return nil, ErrGasUintOverflow
 			}
 		}
 		// Dynamic portion of gas
@@ -281,7 +316,7 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		case err != nil:
 			return nil, err
 		case operation.reverts:
-			return res, errExecutionReverted
+			return res, ErrExecutionReverted
 		case operation.halts:
 			return res, nil
 		case !operation.jumps:
@@ -291,6 +326,27 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 	return nil, nil
 }
 
+// newstack returns the stack for executing the contract. If stack capacity hints
+// are enabled, its capacity is derived from the contract's code.
+func (in *EVMInterpreter) newstack(contract *Contract) *Stack {
+	if !in.cfg.StackCapacityHint {
+		return newstack()
+	}
+	// Initcode has no hash yet, so its hint isn't cached
+	if contract.CodeHash == (common.Hash{}) {
+		return newstackWithCapacity(stackCapacityHint(contract.Code))
+	}
+	hint, ok := in.stackHints[contract.CodeHash]
+	if !ok {
+		if in.stackHints == nil {
+			in.stackHints = make(map[common.Hash]int)
+		}
+		hint = stackCapacityHint(contract.Code)
+		in.stackHints[contract.CodeHash] = hint
+	}
+	return newstackWithCapacity(hint)
+}
+
 // CanRun tells if the contract, passed as an argument, can be
 // run by the current interpreter.
 func (in *EVMInterpreter) CanRun(code []byte) bool {
@@ -17,8 +17,6 @@
 package vm
 
 import (
-	"errors"
-
 	"github.com/ethereum/go-ethereum/params"
 )
 
@@ -29,8 +27,6 @@ type (
 	memorySizeFunc func(*Stack) (size uint64, overflow bool)
 )
 
-var errGasUintOverflow = errors.New("gas uint64 overflow")
-
 type operation struct {
 	// execute is the operation function
 	execute     executionFunc
@@ -65,6 +61,41 @@ var (
 // JumpTable contains the EVM opcodes supported at a given fork.
 type JumpTable [256]operation
 
+// LookupInstructionSet returns the instruction set in effect under the given
+// chain rules.
+func LookupInstructionSet(rules params.Rules) JumpTable {
+	switch {
+	case rules.IsConstantinople:
+		return constantinopleInstructionSet
+	case rules.IsByzantium:
+		return byzantiumInstructionSet
+	case rules.IsEIP158:
+		return spuriousDragonInstructionSet
+	case rules.IsEIP150:
+		return tangerineWhistleInstructionSet
+	case rules.IsHomestead:
+		return homesteadInstructionSet
+	default:
+		return frontierInstructionSet
+	}
+}
+
+// Valid returns whether op is a known and enabled instruction.
+func (jt *JumpTable) Valid(op OpCode) bool {
+	return jt[op].valid
+}
+
+// ConstantGas returns the static portion of the gas charged for executing op.
+func (jt *JumpTable) ConstantGas(op OpCode) uint64 {
+	return jt[op].constantGas
+}
+
+// SetConstantGas overrides the static portion of the gas charged for executing
+// op, e.g. to simulate the effect of repricing it.
+func (jt *JumpTable) SetConstantGas(op OpCode, gas uint64) {
+	jt[op].constantGas = gas
+}
+
 // NewConstantinopleInstructionSet returns the frontier, homestead
 // byzantium and contantinople instructions.
 func newConstantinopleInstructionSet() JumpTable {
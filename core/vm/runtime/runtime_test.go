@@ -203,3 +203,96 @@ func BenchmarkEVM_CREATE2_1200(bench *testing.B) {
 	// initcode size 1200K, repeatedly calls CREATE2 and then modifies the mem contents
 	benchmarkEVM_Create(bench, "5b5862124f80600080f5600152600056")
 }
+
+// stackGrowthCode pushes 100 items onto the stack in a loop, which is more than
+// the capacity hinted by its code, and returns their sum.
+func stackGrowthCode() []byte {
+	code := []byte{
+		byte(vm.PUSH1), 100, byte(vm.PUSH1), 0, byte(vm.MSTORE),
+		byte(vm.JUMPDEST),
+		byte(vm.PUSH1), 1,
+		byte(vm.PUSH1), 0, byte(vm.MLOAD), byte(vm.PUSH1), 1, byte(vm.SWAP1), byte(vm.SUB),
+		byte(vm.DUP1), byte(vm.PUSH1), 0, byte(vm.MSTORE),
+		byte(vm.PUSH1), 5, byte(vm.JUMPI),
+	}
+	for i := 0; i < 99; i++ {
+		code = append(code, byte(vm.ADD))
+	}
+	return append(code, byte(vm.PUSH1), 0, byte(vm.MSTORE), byte(vm.PUSH1), 32, byte(vm.PUSH1), 0, byte(vm.RETURN))
+}
+
+func TestStackCapacityHint(t *testing.T) {
+	for _, hint := range []bool{false, true} {
+		ret, _, err := Execute(stackGrowthCode(), nil, &Config{EVMConfig: vm.Config{StackCapacityHint: hint}})
+		if err != nil {
+			t.Fatalf("hint %v: execution failed: %v", hint, err)
+		}
+		if sum := new(big.Int).SetBytes(ret); sum.Cmp(big.NewInt(100)) != 0 {
+			t.Errorf("hint %v: sum mismatch: have %v, want 100", hint, sum)
+		}
+	}
+}
+
+func benchmarkStackCapacityHint(b *testing.B, hint bool) {
+	var (
+		statedb, _ = state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+		outer      = common.BytesToAddress([]byte("outer"))
+		inner      = common.BytesToAddress([]byte("inner"))
+	)
+	// The outer contract calls the push-heavy inner one until running out of gas
+	code := []byte{byte(vm.JUMPDEST), byte(vm.PUSH1), 0, byte(vm.DUP1), byte(vm.DUP1), byte(vm.DUP1), byte(vm.DUP1), byte(vm.PUSH20)}
+	code = append(code, inner.Bytes()...)
+	code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP), byte(vm.PUSH1), 0, byte(vm.JUMP))
+	statedb.SetCode(outer, code)
+
+	code = nil
+	for i := 0; i < 32; i++ {
+		code = append(code, byte(vm.PUSH1), byte(i))
+	}
+	statedb.SetCode(inner, code)
+
+	cfg := &Config{State: statedb, GasLimit: 1000000, EVMConfig: vm.Config{StackCapacityHint: hint}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Call(outer, nil, cfg)
+	}
+}
+
+func BenchmarkStackCapacityHint(b *testing.B) {
+	b.Run("limit", func(b *testing.B) { benchmarkStackCapacityHint(b, false) })
+	b.Run("hint", func(b *testing.B) { benchmarkStackCapacityHint(b, true) })
+}
+
+func TestMaxReturnDataSize(t *testing.T) {
+	tests := []struct {
+		op   vm.OpCode
+		size byte
+		err  error
+	}{
+		{vm.RETURN, 64, nil},
+		{vm.RETURN, 65, vm.ErrReturnDataLimitExceeded},
+		{vm.REVERT, 64, vm.ErrExecutionReverted},
+		{vm.REVERT, 65, vm.ErrReturnDataLimitExceeded},
+	}
+	for i, tt := range tests {
+		code := []byte{byte(vm.PUSH1), tt.size, byte(vm.PUSH1), 0, byte(tt.op)}
+		cfg := &Config{ChainConfig: params.TestChainConfig, EVMConfig: vm.Config{MaxReturnDataSize: 64}}
+		ret, _, err := Execute(code, nil, cfg)
+		if err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+		if err != vm.ErrReturnDataLimitExceeded && len(ret) != int(tt.size) {
+			t.Errorf("test %d: return data size mismatch: have %d, want %d", i, len(ret), tt.size)
+		}
+	}
+	// Without a limit, the size of the return data is only bounded by gas
+	ret, _, err := Execute([]byte{byte(vm.PUSH2), 0x10, 0x00, byte(vm.PUSH1), 0, byte(vm.RETURN)}, nil, nil)
+	if err != nil {
+		t.Fatalf("unlimited execution failed: %v", err)
+	}
+	if len(ret) != 0x1000 {
+		t.Errorf("unlimited return data size mismatch: have %d, want %d", len(ret), 0x1000)
+	}
+}
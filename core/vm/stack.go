@@ -19,6 +19,8 @@ package vm
 import (
 	"fmt"
 	"math/big"
+
+	"github.com/ethereum/go-ethereum/params"
 )
 
 // Stack is an object for basic stack operations. Items popped to the stack are
@@ -29,7 +31,13 @@ type Stack struct {
 }
 
 func newstack() *Stack {
-	return &Stack{data: make([]*big.Int, 0, 1024)}
+	return newstackWithCapacity(int(params.StackLimit))
+}
+
+// newstackWithCapacity returns a stack preallocated to hold capacity items.
+// The stack grows beyond that if needed.
+func newstackWithCapacity(capacity int) *Stack {
+	return &Stack{data: make([]*big.Int, 0, capacity)}
 }
 
 // Data returns the underlying big.Int array.
@@ -76,7 +84,7 @@ func (st *Stack) Back(n int) *big.Int {
 
 func (st *Stack) require(n int) error {
 	if st.len() < n {
-		return fmt.Errorf("stack underflow (%d <=> %d)", len(st.data), n)
+		return &ErrStackUnderflow{StackLen: len(st.data), Required: n}
 	}
 	return nil
 }
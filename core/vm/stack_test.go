@@ -0,0 +1,98 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestStackOperations(t *testing.T) {
+	var (
+		pool  = newIntPool()
+		stack = newstack()
+	)
+	for i := int64(1); i <= 4; i++ {
+		stack.push(big.NewInt(i))
+	}
+	stack.dup(pool, 1) // 1 2 3 4 4
+	stack.dup(pool, 3) // 1 2 3 4 4 3
+	stack.swap(2)      // 1 2 3 4 3 4
+	stack.swap(6)      // 4 2 3 4 3 1
+
+	want := []int64{4, 2, 3, 4, 3, 1}
+	if stack.len() != len(want) {
+		t.Fatalf("stack size mismatch: have %d, want %d", stack.len(), len(want))
+	}
+	for i, v := range want {
+		if stack.data[i].Int64() != v {
+			t.Errorf("item %d mismatch: have %v, want %d", i, stack.data[i], v)
+		}
+	}
+	if have := stack.peek().Int64(); have != 1 {
+		t.Errorf("peek mismatch: have %d, want 1", have)
+	}
+	if have := stack.Back(2).Int64(); have != 4 {
+		t.Errorf("back mismatch: have %d, want 4", have)
+	}
+	if have := stack.pop().Int64(); have != 1 || stack.len() != 5 {
+		t.Errorf("pop mismatch: have %d with %d items left, want 1 with 5 left", have, stack.len())
+	}
+}
+
+func BenchmarkStackPushPop(b *testing.B) {
+	var (
+		stack = newstack()
+		value = big.NewInt(1)
+	)
+	for i := 0; i < b.N; i++ {
+		stack.push(value)
+		stack.pop()
+	}
+}
+
+func BenchmarkStackPeek(b *testing.B) {
+	stack := newstack()
+	stack.push(big.NewInt(1))
+
+	var value *big.Int
+	for i := 0; i < b.N; i++ {
+		value = stack.peek()
+	}
+	_ = value
+}
+
+func BenchmarkStackDup1(b *testing.B) {
+	var (
+		pool  = newIntPool()
+		stack = newstack()
+	)
+	stack.push(big.NewInt(1))
+	for i := 0; i < b.N; i++ {
+		stack.dup(pool, 1)
+		pool.put(stack.pop())
+	}
+}
+
+func BenchmarkStackSwap1(b *testing.B) {
+	stack := newstack()
+	stack.push(big.NewInt(1))
+	stack.push(big.NewInt(2))
+	for i := 0; i < b.N; i++ {
+		stack.swap(2)
+	}
+}
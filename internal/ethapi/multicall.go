@@ -0,0 +1,1386 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// MulticallArgs represents the arguments of a single call within a multicall
+// batch.
+type MulticallArgs struct {
+	CallArgs
+
+	// BalanceDeltas adjusts the balances of accounts by the given signed amounts
+	// right before the call is executed.
+	BalanceDeltas map[common.Address]*hexutil.Big `json:"balanceDeltas"`
+
+	// AsEOA lists accounts to be treated as externally owned during the call:
+	// they report no code, and calling them doesn't execute any.
+	AsEOA []common.Address `json:"asEOA"`
+
+	// ClearStorage lists accounts whose storage appears cleared during the call,
+	// e.g. to execute initialisation logic again. Slots written by the call
+	// persist like any other write, the remaining ones reappear for the calls
+	// following it.
+	ClearStorage []common.Address `json:"clearStorage"`
+
+	// CreateAddress forces the contract deployed by a contract creation call
+	// to the given address, regardless of the sender's nonce. There must be no
+	// contract at the address yet.
+	CreateAddress *common.Address `json:"createAddress"`
+
+	// Mocks short-circuits calls to the given accounts during the call: their
+	// code isn't executed, the canned output is returned instead and MockGas
+	// charged, sparing the cost of simulating irrelevant sub-calls.
+	Mocks   map[common.Address]hexutil.Bytes `json:"mocks"`
+	MockGas hexutil.Uint64                   `json:"mockGas"`
+
+	// Nonce, if set, is checked against the nonce of the sender, failing the
+	// call with a NonceError on mismatch. Calls don't check nonces otherwise.
+	Nonce *hexutil.Uint64 `json:"nonce"`
+
+	// WarmAddresses and WarmSlots are treated as accessed before the call when
+	// tracking cold accesses, e.g. as touched by an earlier transaction of the
+	// block. Unlike an access list, they don't add to the intrinsic gas.
+	WarmAddresses []common.Address                 `json:"warmAddresses"`
+	WarmSlots     map[common.Address][]common.Hash `json:"warmSlots"`
+}
+
+// MulticallConfig contains the batch level options of a multicall.
+type MulticallConfig struct {
+	// Atomic treats the batch as a single transaction: if any call fails, the
+	// state changes of all preceding calls are rolled back and the remaining
+	// calls are not executed. The calls are still finalised one by one, so every
+	// call observes the preceding ones as committed transactions.
+	Atomic bool `json:"atomic"`
+
+	// DiscardFinalState executes the batch on a copy of the state, so the calls
+	// observe the changes made by each other while neither the calls nor the
+	// overrides affect the state of the backend, e.g. when it is shared.
+	DiscardFinalState bool `json:"discardFinalState"`
+
+	// ExpectedReverts lists the indices of calls which are expected to revert,
+	// such as optional calls of a bundle. Their reverts are still reported, but
+	// don't cause an atomic batch to be rolled back. Other failures still do.
+	ExpectedReverts map[int]bool `json:"expectedReverts"`
+
+	// TrackRefunds reports the storage slots cleared by every call along with
+	// the gas refund it was credited with. As this chain predates EIP-3529, the
+	// refund the call would be credited with under its lower cap is reported
+	// alongside.
+	TrackRefunds bool `json:"trackRefunds"`
+
+	// AccumulateLogs additionally collects the logs of all calls into a single
+	// batch level list. Logs are not part of the state, so they can't be seeded
+	// ahead of a call, but the accumulated list does carry the index of the call
+	// that emitted every log.
+	AccumulateLogs bool `json:"accumulateLogs"`
+
+	// CodeResolver, if set, is consulted for the code of every account without
+	// code in the state upon its first access, allowing code to be fetched on
+	// demand instead of being overridden upfront.
+	CodeResolver CodeResolver `json:"-"`
+
+	// ReferenceFork is the name of a fork, whose gas schedule the gas used by
+	// every call is additionally re-priced against. Only the static portion of
+	// the opcode gas and the intrinsic gas are re-priced.
+	ReferenceFork string `json:"referenceFork"`
+
+	// ReportOpcodes reports the opcodes enabled in the batch, as determined by
+	// the fork of the block and any additionally enabled EIPs, e.g. to check
+	// contracts against the capabilities of the chain.
+	ReportOpcodes bool `json:"reportOpcodes"`
+
+	// TxIndex, if set, executes the batch as if right before the transaction
+	// with the given index within the requested block, i.e. on top of the
+	// parent state with all preceding transactions of the block applied.
+	TxIndex *hexutil.Uint `json:"txIndex"`
+
+	// OnCallComplete, if set, is invoked synchronously with the result of every
+	// call once it finished executing, before the next call starts. Note, a slow
+	// callback slows down the whole batch.
+	OnCallComplete func(index int, result ExecutionResultArgs) `json:"-"`
+
+	// TraceCallGas reports the gas available to, forwarded by and returned to
+	// every CALL-family operation executed by the calls.
+	TraceCallGas bool `json:"traceCallGas"`
+
+	// TraceOutOfGas reports the operation every call running out of gas was
+	// aborted at, along with the gas left when attempting it.
+	TraceOutOfGas bool `json:"traceOutOfGas"`
+
+	// TracePeakOp reports the single operation of every call which charged the
+	// most gas, excluding the gas forwarded to callees.
+	TracePeakOp bool `json:"tracePeakOp"`
+
+	// TrackBackwardJumps counts the jumps to lower program counters taken by
+	// every call, a heuristic for unbounded loops. Calls taking more backward
+	// jumps than BackwardJumpThreshold, if non-zero, are flagged as looping.
+	TrackBackwardJumps    bool           `json:"trackBackwardJumps"`
+	BackwardJumpThreshold hexutil.Uint64 `json:"backwardJumpThreshold"`
+
+	// TrackMemory reports the peak memory size of the call frames executed by
+	// every call, along with the gas charged for expanding memory.
+	TrackMemory bool `json:"trackMemory"`
+
+	// TrackColdAccess reports the number of storage slots loaded and accounts
+	// accessed for the first time by every call, which EIP-2929 charges extra
+	// gas for, along with the access list which would avoid these charges.
+	TrackColdAccess bool `json:"trackColdAccess"`
+
+	// WarmCoinbase overrides whether the coinbase is accessed already when a
+	// call starts, as mandated by EIP-3651, for tracking cold accesses. This
+	// tree predates EIP-3651, so the coinbase starts out cold by default.
+	WarmCoinbase *bool `json:"warmCoinbase"`
+
+	// TrackSelfDestructs reports the contracts self-destructed by every call,
+	// flagging calls destructing any contract.
+	TrackSelfDestructs bool `json:"trackSelfDestructs"`
+
+	// TrackStorage reports the storage slots read or written by every call,
+	// along with their values after the call, e.g. to build a minimal state
+	// witness of the call.
+	TrackStorage bool `json:"trackStorage"`
+
+	// VerifyDeterminism executes every call twice from the same state, flagging
+	// calls whose return data, gas usage, logs or state modifications diverge
+	// between the executions.
+	VerifyDeterminism bool `json:"verifyDeterminism"`
+
+	// ChainIDOverride replaces the chain ID returned by the CHAINID opcode. The
+	// transactions replayed to reach TxIndex still use the actual chain ID.
+	ChainIDOverride *hexutil.Big `json:"chainIdOverride"`
+
+	// Difficulty replaces the difficulty of the block the calls are executed
+	// in, as returned by the DIFFICULTY opcode. This tree predates the merge, so
+	// there is no PREVRANDAO the difficulty would be interpreted as instead.
+	Difficulty *hexutil.Big `json:"difficulty"`
+
+	// FixedTimestamp pins the timestamp of the block the calls are executed in,
+	// as returned by the TIMESTAMP opcode, for the entire batch. Calls don't
+	// override the block individually, so the fixed timestamp applies to every
+	// call, and to the branches of the batch. The transactions replayed to
+	// reach TxIndex still observe the actual timestamp.
+	FixedTimestamp *hexutil.Uint64 `json:"fixedTimestamp"`
+
+	// TrackBalances lists accounts whose balances are reported after every call
+	// and at the end of the batch.
+	TrackBalances []common.Address `json:"trackBalances"`
+
+	// RewriteInput, if set, is invoked with the input of every call right before
+	// it is executed, and may return different input to execute the call with.
+	// The input actually used is reported in the call's result.
+	RewriteInput func(index int, data []byte) []byte `json:"-"`
+
+	// EncodeTransactions reports the RLP encoding of an unsigned transaction
+	// for every successful call, to be signed and sent. The transaction uses the
+	// nonce of the sender at the time of the call and the gas limit of the call,
+	// or the gas it used if none was given. This tree only knows legacy
+	// transactions, so the fee is always set by the gas price.
+	EncodeTransactions bool `json:"encodeTransactions"`
+
+	// TraceFrameGas attributes the gas used by every call to the call frames it
+	// executed, separating the gas spent by a frame itself from the gas spent
+	// by its callees.
+	TraceFrameGas bool `json:"traceFrameGas"`
+
+	// TraceContractGas attributes the gas used by every call to the contracts
+	// it called, including the gas spent by their own callees.
+	TraceContractGas bool `json:"traceContractGas"`
+
+	// TraceWriter, if set, receives the struct logs of every call as JSON, one
+	// object per line, while the call executes. The trace of every call ends
+	// with a summary object carrying its output and gas used. TraceConfig
+	// configures the contents of the logs.
+	TraceWriter io.Writer     `json:"-"`
+	TraceConfig *vm.LogConfig `json:"-"`
+
+	// GasOverrides replaces the static gas cost of the named opcodes for the
+	// whole batch, e.g. to simulate the effect of repricing an opcode. Dynamic
+	// costs, like memory expansion, remain unchanged.
+	GasOverrides map[string]hexutil.Uint64 `json:"gasOverrides"`
+
+	// GroupLogs additionally reports the logs of every call grouped by the
+	// contract that emitted them, preserving their order within each group.
+	GroupLogs bool `json:"groupLogs"`
+
+	// Enforce3607 rejects calls sent from accounts with code, as mandated by
+	// EIP-3607 for transactions.
+	Enforce3607 bool `json:"enforce3607"`
+
+	// RecentHeaders resolves BLOCKHASH from the given headers instead of the
+	// canonical chain, e.g. for contracts accumulating the hashes of recent
+	// blocks. The headers must form a contiguous chain in ascending order below
+	// the block the batch is executed on. Hashes of other blocks are resolved
+	// as usual.
+	RecentHeaders []*types.Header `json:"recentHeaders"`
+
+	// MaxSubcalls caps the number of CALL- and CREATE-family operations every
+	// call may execute across all of its frames. Calls exceeding it fail with
+	// vm.ErrSubcallLimitExceeded and are flagged.
+	MaxSubcalls int `json:"maxSubcalls"`
+
+	// MaxGasPerCall flags the calls using more gas than this, if non-zero, as
+	// violating the gas policy of the batch. The calls are executed regardless.
+	MaxGasPerCall hexutil.Uint64 `json:"maxGasPerCall"`
+
+	// Branching returns the state at the end of the batch as a branch, which
+	// further calls can be executed on and which can be forked cheaply, e.g.
+	// to explore alternative call sequences after a common prefix.
+	Branching bool `json:"-"`
+
+	// DetectOrderDependence additionally executes the calls in the order given
+	// by Permutation, or in reverse if it isn't set, on an isolated branch of
+	// the state, flagging the calls whose outcome differs from the batch. The
+	// reordered calls are executed as on a branch, so only plain calls can be
+	// compared meaningfully.
+	DetectOrderDependence bool  `json:"detectOrderDependence"`
+	Permutation           []int `json:"permutation"`
+
+	// CollectWitness reports the accounts, code and storage slots accessed by
+	// the batch as they were before it, which suffice to execute the batch
+	// again. Proofs are included if the batch is executed on the unmodified
+	// state of a block, i.e. without state overrides and TxIndex.
+	CollectWitness bool `json:"collectWitness"`
+
+	// MemoizeReads serves calls repeating an earlier read from its result
+	// instead of executing them again, as long as no call modified the state
+	// in between. Reads are successful calls which neither modify the state
+	// apart from the sender's nonce nor emit logs. Only plain calls without
+	// per-call options are memoized, and memoized calls are not traced.
+	MemoizeReads bool `json:"memoizeReads"`
+
+	// Invariants are conditions on storage slots checked after every call, the
+	// violated ones being reported by the call's result.
+	Invariants []StorageInvariant `json:"invariants"`
+
+	// TraceInitCode reports the size of the init code of every contract creation
+	// executed by the calls, along with the gas EIP-3860 would charge for it and
+	// whether it would exceed the limit of EIP-3860.
+	TraceInitCode bool `json:"traceInitCode"`
+
+	// TraceCallEdges reports the edges of the call graph of every call, i.e.
+	// the caller, callee and type of the top level call and every CALL- and
+	// CREATE-family operation executed, in execution order. UniqueCallEdges
+	// drops repeated edges.
+	TraceCallEdges  bool `json:"traceCallEdges"`
+	UniqueCallEdges bool `json:"uniqueCallEdges"`
+
+	// TraceCodeIntrospection reports the contracts whose code every call
+	// inspected by EXTCODESIZE, EXTCODEHASH or EXTCODECOPY, as opposed to the
+	// ones it called.
+	TraceCodeIntrospection bool `json:"traceCodeIntrospection"`
+
+	// DetectReentrancy reports the CALL-family operations of every call which
+	// target a contract already executing further up the call stack. This is
+	// merely a pattern to review, not proof of a vulnerability.
+	DetectReentrancy bool `json:"detectReentrancy"`
+
+	// MaxValueTransferred caps the value the calls may transfer in total, as a
+	// guard against absurd inputs. Value transferred by the call itself and by
+	// its CALL, CREATE and SELFDESTRUCT operations is counted as attempted, even
+	// if the transfer is rolled back. The call exceeding the limit is rolled
+	// back and fails with a ValueLimitError, aborting the batch.
+	MaxValueTransferred *hexutil.Big `json:"maxValueTransferred"`
+}
+
+// errSenderNoEOA is returned for calls sent from an account with code if
+// EIP-3607 is enforced.
+var errSenderNoEOA = errors.New("sender not an eoa")
+
+// analysisCache is the process wide cache of JUMPDEST analyses shared by all
+// multicalls whose EVM configuration doesn't specify a cache of its own.
+var analysisCache = vm.NewJumpdestCache(1024)
+
+// WarmAnalysisCache runs the JUMPDEST analysis of the given codes ahead of any
+// multicalls executing them, moving the cost of analysing large contracts off
+// the critical path of latency sensitive batches. Warming is idempotent and may
+// run concurrently with multicalls.
+func WarmAnalysisCache(codes [][]byte) {
+	analysisCache.Warm(codes)
+}
+
+// referenceForks maps the names of the forks gas can be re-priced against to
+// their chain rules.
+var referenceForks = map[string]params.Rules{
+	"frontier":         {},
+	"homestead":        {IsHomestead: true},
+	"tangerineWhistle": {IsHomestead: true, IsEIP150: true},
+	"spuriousDragon":   {IsHomestead: true, IsEIP150: true, IsEIP155: true, IsEIP158: true},
+	"byzantium":        {IsHomestead: true, IsEIP150: true, IsEIP155: true, IsEIP158: true, IsByzantium: true},
+	"constantinople":   {IsHomestead: true, IsEIP150: true, IsEIP155: true, IsEIP158: true, IsByzantium: true, IsConstantinople: true},
+	"petersburg":       {IsHomestead: true, IsEIP150: true, IsEIP155: true, IsEIP158: true, IsByzantium: true, IsConstantinople: true, IsPetersburg: true},
+}
+
+// ExecutionResultArgs is the outcome of a single call within a multicall batch.
+type ExecutionResultArgs struct {
+	ReturnData hexutil.Bytes  `json:"returnData"`
+	GasUsed    hexutil.Uint64 `json:"gasUsed"`
+	Failed     bool           `json:"failed"`
+	Logs       []*types.Log   `json:"logs"`
+	Error      string         `json:"error,omitempty"`
+	ErrorCode  string         `json:"errorCode,omitempty"` // Canonical name of the error, if a known one
+
+	LogsByAddress map[common.Address][]*types.Log `json:"logsByAddress,omitempty"` // Logs grouped by emitting contract
+
+	Input    hexutil.Bytes `json:"input,omitempty"`    // Input the call was executed with, if it was rewritten
+	Selector hexutil.Bytes `json:"selector,omitempty"` // Function selector the input starts with, unless a contract creation
+
+	Transaction hexutil.Bytes `json:"transaction,omitempty"` // RLP encoding of the unsigned transaction making the call
+
+	ExceedsBlockGasLimit bool `json:"exceedsBlockGasLimit"` // Whether the call used more gas than a block can hold
+	TraceStreamed        bool `json:"traceStreamed"`        // Whether the trace of the call was written to the trace writer
+	SubcallLimitExceeded bool `json:"subcallLimitExceeded"` // Whether the call executed more sub-calls than allowed
+	GasPolicyViolated    bool `json:"gasPolicyViolated"`    // Whether the call used more gas than MaxGasPerCall
+
+	GasOverrideDelta *hexutil.Big `json:"gasOverrideDelta,omitempty"` // Gas charged on top of the original schedule due to the gas overrides
+
+	SlotsCleared        *hexutil.Uint64 `json:"slotsCleared,omitempty"`        // Number of non-zero storage slots set to zero
+	CappedRefund        *hexutil.Uint64 `json:"cappedRefund,omitempty"`        // Gas refund after applying the refund cap
+	CappedRefundEIP3529 *hexutil.Uint64 `json:"cappedRefundEIP3529,omitempty"` // Gas refund after applying the refund cap of EIP-3529
+
+	ReferenceGasUsed *hexutil.Uint64 `json:"referenceGasUsed,omitempty"` // Gas used when re-priced against the reference fork
+
+	EffectiveGasPrice *hexutil.Big `json:"effectiveGasPrice"` // Price paid per unit of gas, as reported by a receipt
+
+	CallGas []CallGasArgs `json:"callGas,omitempty"` // Gas accounting of the CALL-family operations executed
+
+	OutOfGasAt *OutOfGasArgs `json:"outOfGasAt,omitempty"` // Operation the call ran out of gas at
+
+	PeakOp *PeakOpArgs `json:"peakOp,omitempty"` // Operation which charged the most gas
+
+	BackwardJumps *hexutil.Uint64 `json:"backwardJumps,omitempty"` // Jumps to lower program counters taken
+	LoopSuspected bool            `json:"loopSuspected"`           // Whether the backward jumps exceeded the threshold
+
+	PeakMemWords    *hexutil.Uint64 `json:"peakMemWords,omitempty"`    // Largest memory size of any call frame, in words
+	MemExpansionGas *hexutil.Uint64 `json:"memExpansionGas,omitempty"` // Gas charged for expanding memory
+
+	ColdSloads          *hexutil.Uint64   `json:"coldSloads,omitempty"`          // Storage slots loaded for the first time
+	ColdAccountAccesses *hexutil.Uint64   `json:"coldAccountAccesses,omitempty"` // Accounts accessed for the first time
+	AccessList          []AccessTupleArgs `json:"accessList,omitempty"`          // Access list covering the cold accesses
+
+	Balances map[common.Address]*hexutil.Big `json:"balances,omitempty"` // Balances of the tracked accounts after the call
+
+	SelfDestructed bool             `json:"selfDestructed"`          // Whether the call self-destructed any contract
+	SelfDestructs  []common.Address `json:"selfDestructs,omitempty"` // Contracts self-destructed by the call, in order
+
+	Storage map[common.Address]map[common.Hash]common.Hash `json:"storage,omitempty"` // Values of the storage slots accessed, after the call
+
+	ViolatedInvariants []hexutil.Uint64 `json:"violatedInvariants,omitempty"` // Indices of the invariants the call violated
+
+	OrderDependent bool `json:"orderDependent"` // Whether the outcome of the call depends on the order of the batch
+
+	Memoized bool `json:"memoized"` // Whether the result repeats an earlier read instead of executing the call
+
+	Nondeterministic bool     `json:"nondeterministic"`      // Whether repeated executions of the call diverged
+	Divergences      []string `json:"divergences,omitempty"` // Aspects in which the executions diverged
+
+	Precompile *PrecompileArgs `json:"precompile,omitempty"` // Precompile execution, if the call targets one
+
+	FrameGas    []FrameGasArgs                    `json:"frameGas,omitempty"`    // Gas used by the call frames, in the order of entry
+	ContractGas map[common.Address]hexutil.Uint64 `json:"contractGas,omitempty"` // Gas used by the called contracts, including their callees
+
+	CallEdges []CallEdgeArgs `json:"callEdges,omitempty"` // Edges of the call graph, in execution order
+
+	InitCodes []InitCodeArgs `json:"initCodes,omitempty"` // Init code of the contract creations, in execution order
+
+	Reentries []ReentryArgs `json:"reentries,omitempty"` // Calls into contracts already on the call stack
+
+	CodeIntrospections []CodeIntrospectionArgs `json:"codeIntrospections,omitempty"` // Code inspections, in order of first occurrence
+
+	ValueTransferred *hexutil.Big `json:"valueTransferred,omitempty"` // Value the call attempted to transfer
+
+	Err error `json:"-"` // Error the call failed with, see the Call*Error types
+}
+
+// CallGasArgs describes how gas was forwarded by a CALL-family operation.
+type CallGasArgs struct {
+	Op           string          `json:"op"`
+	Depth        int             `json:"depth"`
+	PC           hexutil.Uint64  `json:"pc"`
+	To           common.Address  `json:"to"`
+	GasAvailable hexutil.Uint64  `json:"gasAvailable"` // Gas available to the caller before the operation
+	GasForwarded hexutil.Uint64  `json:"gasForwarded"` // Gas passed to the callee, stipend included
+	Stipend      hexutil.Uint64  `json:"stipend"`      // Stipend granted for transferring value
+	GasReturned  *hexutil.Uint64 `json:"gasReturned"`  // Gas returned by the callee, nil if the caller didn't resume
+}
+
+// AccessTupleArgs is an entry of an EIP-2930 access list.
+type AccessTupleArgs struct {
+	Address     common.Address `json:"address"`
+	StorageKeys []common.Hash  `json:"storageKeys"`
+}
+
+// Gas costs of EIP-2929 and EIP-2930, which postdate this tree.
+const (
+	accessListAddressGas    = 2400 // Intrinsic cost of an address in an access list
+	accessListStorageKeyGas = 1900 // Intrinsic cost of a storage key in an access list
+
+	coldAccountAccessSaving = 2600 - 100 // Cold account access cost less the warm access cost
+	coldSloadSaving         = 2100 - 100 // Cold storage load cost less the warm load cost
+)
+
+// AccessListGasDelta returns the difference in gas a transaction making the
+// call would use if it included the access list discovered by tracking cold
+// accesses, in a fork where EIP-2929 and EIP-2930 are active. A negative delta
+// means including the access list is worthwhile, a zero one is returned if cold
+// accesses weren't tracked.
+//
+// Only storage loads are covered, cold slots only written to aren't listed.
+func (r ExecutionResultArgs) AccessListGasDelta() int64 {
+	if r.ColdSloads == nil || r.ColdAccountAccesses == nil {
+		return 0
+	}
+	var delta int64
+	for _, entry := range r.AccessList {
+		delta += accessListAddressGas + accessListStorageKeyGas*int64(len(entry.StorageKeys))
+	}
+	return delta - coldAccountAccessSaving*int64(*r.ColdAccountAccesses) - coldSloadSaving*int64(*r.ColdSloads)
+}
+
+// OutOfGasArgs describes the operation a call ran out of gas at.
+type OutOfGasArgs struct {
+	Address common.Address `json:"address"` // Contract executing the operation
+	Depth   int            `json:"depth"`
+	PC      hexutil.Uint64 `json:"pc"`
+	Op      string         `json:"op"`
+	Gas     hexutil.Uint64 `json:"gas"`  // Gas left before the operation
+	Cost    hexutil.Uint64 `json:"cost"` // Gas charged for the operation, as far as it was computed
+}
+
+// PeakOpArgs describes the operation of a call which charged the most gas.
+type PeakOpArgs struct {
+	Address common.Address `json:"address"` // Contract executing the operation
+	Depth   int            `json:"depth"`
+	PC      hexutil.Uint64 `json:"pc"`
+	Op      string         `json:"op"`
+	Gas     hexutil.Uint64 `json:"gas"` // Gas charged, excluding the gas forwarded to callees
+}
+
+// Init code metering of EIP-3860, which postdates this tree.
+const (
+	initCodeWordGas = 2                      // Gas charged per word of init code
+	maxInitCodeSize = 2 * params.MaxCodeSize // Maximum size of init code
+)
+
+// InitCodeArgs describes the init code of a contract creation. The gas isn't
+// charged by this tree, it's what EIP-3860 would charge for the init code.
+type InitCodeArgs struct {
+	Op           string         `json:"op"`
+	Depth        int            `json:"depth"` // Depth the init code is executed at
+	Size         hexutil.Uint64 `json:"size"`
+	Gas          hexutil.Uint64 `json:"gas"`
+	ExceedsLimit bool           `json:"exceedsLimit"` // Whether the init code is larger than EIP-3860 allows
+}
+
+// newInitCodeArgs describes init code of the given size.
+func newInitCodeArgs(op vm.OpCode, depth int, size uint64) InitCodeArgs {
+	return InitCodeArgs{
+		Op:           op.String(),
+		Depth:        depth,
+		Size:         hexutil.Uint64(size),
+		Gas:          hexutil.Uint64((size + 31) / 32 * initCodeWordGas),
+		ExceedsLimit: size > maxInitCodeSize,
+	}
+}
+
+// CallEdgeArgs describes an edge of the call graph of a call. Contracts created
+// are the callees of CREATE and CREATE2 edges.
+type CallEdgeArgs struct {
+	From common.Address `json:"from"`
+	To   common.Address `json:"to"`
+	Type string         `json:"type"`
+}
+
+// CodeIntrospectionArgs describes the inspection of the code of an account by a
+// call. Repeated inspections by the same operation are reported once.
+type CodeIntrospectionArgs struct {
+	Address common.Address `json:"address"`
+	Op      string         `json:"op"`
+}
+
+// ReentryArgs describes a call into a contract already executing further up the
+// call stack. Delegated re-entries execute the code of the contract within the
+// context of the calling frame, rather than entering the contract again.
+type ReentryArgs struct {
+	Address    common.Address `json:"address"` // Contract re-entered
+	Op         string         `json:"op"`
+	Depth      int            `json:"depth"`      // Depth of the re-entering frame
+	OuterDepth int            `json:"outerDepth"` // Depth of the outermost frame executing the contract
+	Delegate   bool           `json:"delegate"`   // Whether the re-entry is by DELEGATECALL or CALLCODE
+}
+
+// FrameGasArgs describes the gas used by a call frame.
+type FrameGasArgs struct {
+	Op       string         `json:"op"`
+	To       common.Address `json:"to"`
+	Depth    int            `json:"depth"`
+	TotalGas hexutil.Uint64 `json:"totalGas"` // Gas used by the frame, including its callees
+	SelfGas  hexutil.Uint64 `json:"selfGas"`  // Gas used by the frame, excluding its callees
+}
+
+// StorageInvariant is a condition on a storage slot, which must hold after every
+// call of a multicall batch. The conditions set are combined.
+type StorageInvariant struct {
+	Address common.Address `json:"address"`
+	Slot    common.Hash    `json:"slot"`
+
+	Value         *common.Hash `json:"value"`         // Value the slot must hold, if set
+	Unchanged     bool         `json:"unchanged"`     // Whether the slot must keep its value from before the call
+	NonDecreasing bool         `json:"nonDecreasing"` // Whether the slot, as an unsigned integer, must not decrease
+}
+
+// holds reports whether the invariant holds for the slot, given its values
+// before and after a call.
+func (inv *StorageInvariant) holds(prev, cur common.Hash) bool {
+	switch {
+	case inv.Value != nil && cur != *inv.Value:
+		return false
+	case inv.Unchanged && cur != prev:
+		return false
+	case inv.NonDecreasing && cur.Big().Cmp(prev.Big()) < 0:
+		return false
+	}
+	return true
+}
+
+// PrecompileArgs describes the execution of a precompiled contract called
+// directly by a multicall call. Whether it succeeded is reported by the call.
+type PrecompileArgs struct {
+	Address     common.Address `json:"address"`
+	RequiredGas hexutil.Uint64 `json:"requiredGas"` // Gas charged by the precompile for the call's input
+}
+
+// activePrecompiles returns the precompiled contracts available under the
+// given chain rules.
+func activePrecompiles(rules params.Rules) map[common.Address]vm.PrecompiledContract {
+	switch {
+	case rules.IsIstanbul:
+		return vm.PrecompiledContractsIstanbul
+	case rules.IsByzantium:
+		return vm.PrecompiledContractsByzantium
+	default:
+		return vm.PrecompiledContractsHomestead
+	}
+}
+
+// MulticallResult is the outcome of a multicall batch.
+type MulticallResult struct {
+	Calls []ExecutionResultArgs `json:"calls"`
+
+	// RevertedAt is the index of the call that caused an atomic batch to be
+	// rolled back, or nil if the batch was not rolled back.
+	RevertedAt *hexutil.Uint64 `json:"revertedAt,omitempty"`
+
+	// ValueLimitExceededAt is the index of the call which exceeded the limit
+	// of the value transferred, aborting the batch.
+	ValueLimitExceededAt *hexutil.Uint64 `json:"valueLimitExceededAt,omitempty"`
+
+	// AllLogs contains the logs emitted by all calls in execution order if the
+	// batch was configured to accumulate them. The logs of a rolled back atomic
+	// batch are discarded.
+	AllLogs []*types.Log `json:"allLogs,omitempty"`
+
+	// FinalBalances contains the balances of the tracked accounts at the end of
+	// the batch, after a rolled back atomic batch has been reverted.
+	FinalBalances map[common.Address]*hexutil.Big `json:"finalBalances,omitempty"`
+
+	// GasUsed is the total gas used by all executed calls. ExceedsBlockGasLimit
+	// reports whether it is more than a block can hold, i.e. the batch can't be
+	// included in a single block.
+	GasUsed              hexutil.Uint64 `json:"gasUsed"`
+	ExceedsBlockGasLimit bool           `json:"exceedsBlockGasLimit"`
+
+	// OrderDependent reports whether the outcome of any call differed when the
+	// batch was reordered, if it was configured to detect order dependence.
+	OrderDependent bool `json:"orderDependent"`
+
+	// EnabledOpcodes are the opcodes available to the calls, in numeric order,
+	// if the batch was configured to report them.
+	EnabledOpcodes []string `json:"enabledOpcodes,omitempty"`
+
+	// Witness is the part of the state accessed by the batch, if the batch was
+	// configured to collect it.
+	Witness *StateWitness `json:"witness,omitempty"`
+
+	// Branch is the state at the end of the batch, if it was executed in
+	// branching mode.
+	Branch *MulticallBranch `json:"-"`
+}
+
+// enabledOpcodes returns the names of the opcodes the interpreter enables under
+// the given rules and configuration.
+func enabledOpcodes(rules params.Rules, cfg vm.Config) []string {
+	jt := vm.JumpTable(cfg.JumpTable)
+	if !jt.Valid(vm.STOP) {
+		jt = vm.LookupInstructionSet(rules)
+		for _, eip := range cfg.ExtraEips {
+			// Unknown EIPs are skipped by the interpreter as well
+			vm.EnableEIP(eip, &jt)
+		}
+	}
+	var ops []string
+	for i := 0; i < 256; i++ {
+		if op := vm.OpCode(i); jt.Valid(op) {
+			ops = append(ops, op.String())
+		}
+	}
+	return ops
+}
+
+// trackedBalances returns the balances of the given accounts in the state, or
+// nil if there are none.
+func trackedBalances(state *state.StateDB, addrs []common.Address) map[common.Address]*hexutil.Big {
+	if len(addrs) == 0 {
+		return nil
+	}
+	balances := make(map[common.Address]*hexutil.Big, len(addrs))
+	for _, addr := range addrs {
+		balances[addr] = (*hexutil.Big)(new(big.Int).Set(state.GetBalance(addr)))
+	}
+	return balances
+}
+
+// toMessage converts the call arguments into a message that can be applied on
+// top of the multicall state.
+//
+// Contrary to eth_call, the gas price defaults to zero as the accounts within a
+// batch are not topped up, so senders only pay for the gas they explicitly ask
+// to be priced.
+func (args *MulticallArgs) toMessage(b Backend, globalGasCap *big.Int) types.Message {
+	// Set sender address or use a default if none specified
+	var addr common.Address
+	if args.From == nil {
+		if wallets := b.AccountManager().Wallets(); len(wallets) > 0 {
+			if accounts := wallets[0].Accounts(); len(accounts) > 0 {
+				addr = accounts[0].Address
+			}
+		}
+	} else {
+		addr = *args.From
+	}
+	// Set default gas & gas price if none were set
+	gas := uint64(math.MaxUint64 / 2)
+	if args.Gas != nil {
+		gas = uint64(*args.Gas)
+	}
+	if globalGasCap != nil && globalGasCap.Uint64() < gas {
+		log.Warn("Caller gas above allowance, capping", "requested", gas, "cap", globalGasCap)
+		gas = globalGasCap.Uint64()
+	}
+	gasPrice := new(big.Int)
+	if args.GasPrice != nil {
+		gasPrice = args.GasPrice.ToInt()
+	}
+	value := new(big.Int)
+	if args.Value != nil {
+		value = args.Value.ToInt()
+	}
+	var data []byte
+	if args.Data != nil {
+		data = []byte(*args.Data)
+	}
+	if args.Nonce != nil {
+		return types.NewMessage(addr, args.To, uint64(*args.Nonce), value, gas, gasPrice, data, true)
+	}
+	return types.NewMessage(addr, args.To, 0, value, gas, gasPrice, data, false)
+}
+
+// encodeTransaction returns the RLP encoding of the unsigned transaction sending
+// the message with the given nonce and gas limit.
+func encodeTransaction(nonce uint64, msg core.Message, gas uint64) (hexutil.Bytes, error) {
+	var tx *types.Transaction
+	if to := msg.To(); to != nil {
+		tx = types.NewTransaction(nonce, *to, msg.Value(), gas, msg.GasPrice(), msg.Data())
+	} else {
+		tx = types.NewContractCreation(nonce, msg.Value(), gas, msg.GasPrice(), msg.Data())
+	}
+	return rlp.EncodeToBytes(tx)
+}
+
+// plain reports whether the call is a plain message call, without any per-call
+// options modifying its execution or the accesses reported.
+func (args *MulticallArgs) plain() bool {
+	return len(args.BalanceDeltas) == 0 && len(args.AsEOA) == 0 && len(args.ClearStorage) == 0 && args.CreateAddress == nil && len(args.Mocks) == 0 && args.Nonce == nil &&
+		len(args.WarmAddresses) == 0 && len(args.WarmSlots) == 0
+}
+
+// prepare sets up the execution of the call on top of db, returning the state
+// and the vm config to execute the call with, the options of the call applied.
+// The balance deltas of the call are applied to db right away. If an error is
+// returned, the call fails without being executed.
+func (args *MulticallArgs) prepare(msg core.Message, db vm.StateDB, vmCfg vm.Config, enforce3607 bool) (vm.StateDB, vm.Config, error) {
+	callDB := db
+	if len(args.AsEOA) > 0 {
+		callDB = newEOAStateDB(callDB, args.AsEOA)
+	}
+	if len(args.ClearStorage) > 0 {
+		callDB = newClearedStateDB(callDB, args.ClearStorage)
+	}
+	// Transactions can't originate from accounts with code (EIP-3607), so
+	// reject such calls without executing them if configured to.
+	if enforce3607 && callDB.GetCodeSize(msg.From()) > 0 {
+		return callDB, vmCfg, &detailedError{err: errSenderNoEOA, detail: "address " + msg.From().Hex()}
+	}
+	// Ensure a forced creation address is available
+	if args.CreateAddress != nil {
+		switch {
+		case msg.To() != nil:
+			return callDB, vmCfg, errors.New("creation address forced for a call which is no contract creation")
+		case callDB.GetCodeSize(*args.CreateAddress) > 0 || callDB.GetNonce(*args.CreateAddress) != 0:
+			return callDB, vmCfg, &detailedError{err: vm.ErrContractAddressCollision, detail: "forced creation address " + args.CreateAddress.Hex()}
+		}
+	}
+	// Adjust the balances the call is executed against, if requested
+	if err := args.applyBalanceDeltas(db); err != nil {
+		return callDB, vmCfg, err
+	}
+	vmCfg.CreateAddress = args.CreateAddress
+	if len(args.Mocks) > 0 {
+		vmCfg.Mocks = make(map[common.Address][]byte, len(args.Mocks))
+		for addr, output := range args.Mocks {
+			vmCfg.Mocks[addr] = output
+		}
+		vmCfg.MockGas = uint64(args.MockGas)
+	}
+	return callDB, vmCfg, nil
+}
+
+// applyBalanceDeltas adjusts the balances of the accounts in the state by the
+// deltas of the call. No balance is modified if any would become negative.
+func (args *MulticallArgs) applyBalanceDeltas(db vm.StateDB) error {
+	for addr, delta := range args.BalanceDeltas {
+		if delta == nil {
+			continue
+		}
+		if balance := db.GetBalance(addr); new(big.Int).Add(balance, delta.ToInt()).Sign() < 0 {
+			return fmt.Errorf("balance delta %v underflows balance of %v (%v)", delta.ToInt(), addr.Hex(), balance)
+		}
+	}
+	for addr, delta := range args.BalanceDeltas {
+		switch {
+		case delta == nil:
+		case delta.ToInt().Sign() < 0:
+			db.SubBalance(addr, new(big.Int).Neg(delta.ToInt()))
+		default:
+			db.AddBalance(addr, delta.ToInt())
+		}
+	}
+	return nil
+}
+
+// newMulticallEVM creates an EVM for executing msg on top of the multicall state,
+// accessed through db. The block context is retrieved from the backend, but any
+// modification GetEVM makes to the state to ease eth_call (i.e. topping up the
+// sender's balance) is rolled back, since the calls within a batch observe each
+// other's balances.
+//
+// If chainConfig is nil, the chain configuration of the backend is used. The
+// block hashes in hashes take precedence over the ones of the backend's chain.
+func newMulticallEVM(ctx context.Context, b Backend, msg core.Message, state *state.StateDB, db vm.StateDB, header *types.Header, chainConfig *params.ChainConfig, hashes map[uint64]common.Hash, vmCfg vm.Config) (*vm.EVM, func() error, error) {
+	snapshot := state.Snapshot()
+	evm, vmError, err := b.GetEVM(ctx, msg, state, header)
+	state.RevertToSnapshot(snapshot)
+	if err != nil {
+		return nil, nil, err
+	}
+	if chainConfig == nil {
+		chainConfig = evm.ChainConfig()
+	}
+	context := evm.Context
+	if len(hashes) > 0 {
+		getHash := context.GetHash
+		context.GetHash = func(n uint64) common.Hash {
+			if hash, ok := hashes[n]; ok {
+				return hash
+			}
+			return getHash(n)
+		}
+	}
+	return vm.NewEVM(context, db, chainConfig, vmCfg), vmError, nil
+}
+
+// recentHeaderHashes validates that the given headers form a contiguous chain
+// below the block with the given number and returns their hashes by number.
+func recentHeaderHashes(headers []*types.Header, number *big.Int) (map[uint64]common.Hash, error) {
+	hashes := make(map[uint64]common.Hash, len(headers))
+	for i, header := range headers {
+		if header == nil || header.Number == nil {
+			return nil, fmt.Errorf("recent header %d missing", i)
+		}
+		if i > 0 {
+			parent := headers[i-1]
+			if header.Number.Cmp(new(big.Int).Add(parent.Number, common.Big1)) != 0 || header.ParentHash != parent.Hash() {
+				return nil, fmt.Errorf("recent header %d (#%v) not a child of #%v", i, header.Number, parent.Number)
+			}
+		}
+		hashes[header.Number.Uint64()] = header.Hash()
+	}
+	if len(headers) > 0 && headers[len(headers)-1].Number.Cmp(number) >= 0 {
+		return nil, fmt.Errorf("recent headers reach block #%v, executing on #%v", headers[len(headers)-1].Number, number)
+	}
+	return hashes, nil
+}
+
+// stateAtTransaction returns the state of the parent of the requested block,
+// with the transactions of the block preceding txIndex applied on top. It also
+// returns the header of the block.
+func stateAtTransaction(ctx context.Context, b Backend, blockNr rpc.BlockNumber, txIndex int) (*state.StateDB, *types.Header, error) {
+	block, err := b.BlockByNumber(ctx, blockNr)
+	if block == nil || err != nil {
+		return nil, nil, err
+	}
+	if block.NumberU64() == 0 {
+		return nil, nil, errors.New("genesis is not executable")
+	}
+	if txIndex > len(block.Transactions()) {
+		return nil, nil, fmt.Errorf("transaction index %d out of range for block #%d", txIndex, block.NumberU64())
+	}
+	statedb, _, err := b.StateAndHeaderByNumber(ctx, rpc.BlockNumber(block.NumberU64()-1))
+	if statedb == nil || err != nil {
+		return nil, nil, err
+	}
+	var (
+		header      = block.Header()
+		signer      = types.MakeSigner(b.ChainConfig(), block.Number())
+		deleteEmpty = b.ChainConfig().IsEIP158(block.Number())
+		gp          = new(core.GasPool).AddGas(block.GasLimit())
+	)
+	for i, tx := range block.Transactions()[:txIndex] {
+		msg, err := tx.AsMessage(signer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("transaction %#x invalid: %v", tx.Hash(), err)
+		}
+		statedb.Prepare(tx.Hash(), block.Hash(), i)
+
+		evm, vmError, err := newMulticallEVM(ctx, b, msg, statedb, statedb, header, nil, nil, vm.Config{})
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, _, _, err := core.ApplyMessage(evm, msg, gp); err != nil {
+			return nil, nil, fmt.Errorf("transaction %#x failed: %v", tx.Hash(), err)
+		}
+		if err := vmError(); err != nil {
+			return nil, nil, err
+		}
+		// Ensure any modifications are committed to the state
+		statedb.Finalise(deleteEmpty)
+	}
+	return statedb, header, nil
+}
+
+// DoMulticall executes the given calls sequentially on top of the state of the
+// requested block. Every call observes the state changes made by the calls
+// before it. A failing call doesn't abort the batch, unless it is configured to
+// be atomic.
+func DoMulticall(ctx context.Context, b Backend, calls []MulticallArgs, blockNr rpc.BlockNumber, overrides map[common.Address]account, config MulticallConfig, vmCfg vm.Config, timeout time.Duration, globalGasCap *big.Int) (*MulticallResult, error) {
+	defer func(start time.Time) {
+		log.Debug("Executing EVM multicall finished", "calls", len(calls), "runtime", time.Since(start))
+	}(time.Now())
+
+	var (
+		state  *state.StateDB
+		header *types.Header
+		err    error
+	)
+	if config.TxIndex != nil {
+		state, header, err = stateAtTransaction(ctx, b, blockNr, int(*config.TxIndex))
+	} else {
+		state, header, err = b.StateAndHeaderByNumber(ctx, blockNr)
+	}
+	if state == nil || err != nil {
+		return nil, &StateUnavailableError{BlockNr: blockNr, Err: err}
+	}
+	// Finalising the calls clears the journal, so a snapshot can't span the
+	// batch. Leave the state untouched by working on a copy instead.
+	if config.DiscardFinalState {
+		state = state.Copy()
+	}
+	if err := applyOverrides(state, overrides); err != nil {
+		return nil, err
+	}
+	// Setup context so it may be cancelled once the batch has completed
+	// or, in case of unmetered gas, setup a context with a timeout.
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	// Substitute the chain ID observed by the calls, if requested
+	var chainConfig *params.ChainConfig
+	if config.ChainIDOverride != nil {
+		cpy := *b.ChainConfig()
+		cpy.ChainID = new(big.Int).Set(config.ChainIDOverride.ToInt())
+		chainConfig = &cpy
+	}
+	// Substitute the difficulty and timestamp of the block, if requested
+	if config.Difficulty != nil || config.FixedTimestamp != nil {
+		header = types.CopyHeader(header)
+	}
+	if config.Difficulty != nil {
+		if config.Difficulty.ToInt().Sign() < 0 {
+			return nil, fmt.Errorf("negative difficulty %v", config.Difficulty.ToInt())
+		}
+		header.Difficulty = new(big.Int).Set(config.Difficulty.ToInt())
+	}
+	if config.FixedTimestamp != nil {
+		header.Time = uint64(*config.FixedTimestamp)
+	}
+	hashes, err := recentHeaderHashes(config.RecentHeaders, header.Number)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		rules     = b.ChainConfig().Rules(header.Number)
+		active    = vm.LookupInstructionSet(rules)
+		reference *params.Rules
+	)
+	if config.ReferenceFork != "" {
+		fork, ok := referenceForks[config.ReferenceFork]
+		if !ok {
+			return nil, fmt.Errorf("unknown reference fork %q", config.ReferenceFork)
+		}
+		reference = &fork
+	}
+	// Patch the gas schedule of the batch, if requested
+	var gasDeltas map[vm.OpCode]int64
+	if len(config.GasOverrides) > 0 {
+		jt := active
+		for _, eip := range vmCfg.ExtraEips {
+			if err := vm.EnableEIP(eip, &jt); err != nil {
+				return nil, err
+			}
+		}
+		gasDeltas = make(map[vm.OpCode]int64, len(config.GasOverrides))
+		for name, gas := range config.GasOverrides {
+			op := vm.StringToOp(name)
+			if op.String() != name || !jt.Valid(op) {
+				return nil, fmt.Errorf("unknown opcode %q in gas overrides", name)
+			}
+			gasDeltas[op] = int64(gas) - int64(jt.ConstantGas(op))
+			jt.SetConstantGas(op, uint64(gas))
+		}
+		vmCfg.JumpTable, active = jt, jt
+	}
+	if config.MaxSubcalls > 0 {
+		vmCfg.MaxSubcalls = config.MaxSubcalls
+	}
+	if vmCfg.JumpdestCache == nil {
+		vmCfg.JumpdestCache = analysisCache
+	}
+	var (
+		deleteEmpty = b.ChainConfig().IsEIP158(header.Number)
+		gp          = new(core.GasPool).AddGas(math.MaxUint64)
+		result      = &MulticallResult{Calls: make([]ExecutionResultArgs, 0, len(calls))}
+	)
+	if config.AccumulateLogs {
+		result.AllLogs = []*types.Log{}
+	}
+	if config.ReportOpcodes {
+		result.EnabledOpcodes = enabledOpcodes(rules, vmCfg)
+	}
+	// Keep the state preceding an atomic batch around to roll it back to, the
+	// calls are finalised one by one like those of any other batch.
+	pre := state
+	if config.Atomic {
+		pre = state.Copy()
+	}
+	var (
+		db       vm.StateDB = state
+		resolver *resolvingStateDB
+	)
+	// Value transferred by the calls so far, if limited
+	transferred := new(big.Int)
+	if config.CodeResolver != nil {
+		resolver = newResolvingStateDB(state, config.CodeResolver)
+		db = resolver
+	}
+	var witness *witnessStateDB
+	if config.CollectWitness {
+		witness = newWitnessStateDB(db, state.Copy())
+		db = witness
+	}
+	var (
+		versions *versionStateDB
+		reads    map[readKey]ExecutionResultArgs
+	)
+	if config.MemoizeReads {
+		versions, reads = newVersionStateDB(db), make(map[readKey]ExecutionResultArgs)
+		db = versions
+	}
+	// Execute the calls reordered on an isolated branch of the state first, to
+	// compare the outcomes of the batch against
+	var (
+		order     []int
+		reordered []ExecutionResultArgs
+	)
+	if config.DetectOrderDependence {
+		if order, err = multicallPermutation(config.Permutation, len(calls)); err != nil {
+			return nil, err
+		}
+		if reordered, err = executeReordered(ctx, newMulticallBranch(b, state, header, chainConfig, hashes, vmCfg, config.Enforce3607, deleteEmpty, globalGasCap, 0), calls, order); err != nil {
+			return nil, err
+		}
+	}
+	for i, call := range calls {
+		msg := call.toMessage(b, globalGasCap)
+		if config.RewriteInput != nil {
+			data := config.RewriteInput(i, common.CopyBytes(msg.Data()))
+			msg = types.NewMessage(msg.From(), msg.To(), msg.Nonce(), msg.Value(), msg.Gas(), msg.GasPrice(), data, msg.CheckNonce())
+		}
+
+		txHash := common.BigToHash(big.NewInt(int64(i)))
+		state.Prepare(txHash, header.Hash(), i)
+		nonce := state.GetNonce(msg.From())
+
+		invariants := make([]common.Hash, len(config.Invariants))
+		for j, inv := range config.Invariants {
+			invariants[j] = state.GetState(inv.Address, inv.Slot)
+		}
+
+		var (
+			ret     []byte
+			gas     uint64
+			failed  bool
+			err     error
+			tracers []resultTracer
+			callDB  vm.StateDB
+			callCfg vm.Config
+
+			failure          error
+			subcallsExceeded bool
+			divergences      []string
+
+			spending     *valueTracer
+			callSnapshot int
+			limitErr     *ValueLimitError
+		)
+		callDB, callCfg, err = call.prepare(msg, db, vmCfg, config.Enforce3607)
+
+		// Repeat the result of an identical read if the state is unchanged
+		var (
+			read   *readKey
+			cached *ExecutionResultArgs
+		)
+		if err == nil && versions != nil && call.plain() {
+			versions.sender = msg.From()
+			read = &readKey{call: readHash(msg), version: versions.version}
+			if res, ok := reads[*read]; ok {
+				cached = &res
+			}
+		}
+		if cached != nil {
+			// Executing the call would have increased the sender's nonce
+			db.SetNonce(msg.From(), db.GetNonce(msg.From())+1)
+			gas = uint64(cached.GasUsed)
+		}
+		if err == nil && cached == nil {
+			if config.TrackRefunds {
+				tracers = append(tracers, newRefundTracer(state.GetRefund, msg.Gas()))
+			}
+			if reference != nil {
+				tracers = append(tracers, newRepricingTracer(msg, rules, &active, *reference))
+			}
+			if config.TraceCallGas {
+				tracers = append(tracers, newCallGasTracer())
+			}
+			if config.TraceOutOfGas {
+				tracers = append(tracers, newOutOfGasTracer())
+			}
+			if config.TracePeakOp {
+				tracers = append(tracers, newPeakOpTracer())
+			}
+			if config.MaxValueTransferred != nil {
+				spending = newValueTracer()
+				tracers = append(tracers, spending)
+				callSnapshot = state.Snapshot()
+			}
+			if config.TrackBackwardJumps {
+				tracers = append(tracers, newBackwardJumpTracer(uint64(config.BackwardJumpThreshold)))
+			}
+			if config.TrackMemory {
+				tracers = append(tracers, newMemoryTracer())
+			}
+			if config.TrackColdAccess {
+				warmCoinbase := config.WarmCoinbase != nil && *config.WarmCoinbase
+				tracers = append(tracers, newAccessTracer(msg, rules, warmCoinbase, call.WarmAddresses, call.WarmSlots))
+			}
+			if config.TraceInitCode {
+				tracers = append(tracers, newInitCodeTracer())
+			}
+			if config.TraceCallEdges {
+				tracers = append(tracers, newCallEdgeTracer(config.UniqueCallEdges))
+			}
+			if config.TraceCodeIntrospection {
+				tracers = append(tracers, newCodeIntrospectionTracer())
+			}
+			if config.DetectReentrancy {
+				tracers = append(tracers, newReentrancyTracer())
+			}
+			if config.TrackSelfDestructs {
+				tracers = append(tracers, newSelfDestructTracer(callDB))
+			}
+			if config.TrackStorage {
+				tracers = append(tracers, newStorageTracer(callDB))
+			}
+			if config.TraceFrameGas || config.TraceContractGas {
+				tracers = append(tracers, newFrameGasTracer(rules, config.TraceFrameGas, config.TraceContractGas))
+			}
+			if gasDeltas != nil {
+				tracers = append(tracers, newGasOverrideTracer(gasDeltas))
+			}
+			if config.TraceWriter != nil {
+				tracers = append(tracers, newStreamTracer(config.TraceConfig, config.TraceWriter))
+			}
+			callCfg = withTracers(callCfg, tracers)
+			// Execute the call once without tracing and roll it back, to compare
+			// the actual execution against
+			var (
+				probe  *executionDigest
+				execDB = callDB
+			)
+			if config.VerifyDeterminism {
+				probeCfg := callCfg
+				probeCfg.Debug, probeCfg.Tracer = false, nil
+				if probe, err = probeCall(ctx, b, msg, state, callDB, header, chainConfig, hashes, probeCfg, txHash); err != nil {
+					return nil, err
+				}
+				execDB = newRecordingStateDB(callDB)
+			}
+			evm, vmError, evmErr := newMulticallEVM(ctx, b, msg, state, execDB, header, chainConfig, hashes, callCfg)
+			if evmErr != nil {
+				return nil, evmErr
+			}
+			// Wait for the context to be done and cancel the evm. Even if the
+			// EVM has finished, cancelling may be done (repeatedly)
+			go func() {
+				<-ctx.Done()
+				evm.Cancel()
+			}()
+			ret, gas, failed, err = core.ApplyMessage(evm, msg, gp)
+			if err := vmError(); err != nil {
+				return nil, err
+			}
+			if resolver != nil {
+				if err := resolver.Error(); err != nil {
+					return nil, err
+				}
+			}
+			// If the timer caused an abort, return an appropriate error message
+			if evm.Cancelled() {
+				return nil, fmt.Errorf("execution aborted (timeout = %v)", timeout)
+			}
+			failure = evm.Failure()
+			subcallsExceeded = vmCfg.MaxSubcalls > 0 && evm.Subcalls() > vmCfg.MaxSubcalls
+			if probe != nil {
+				digest := newExecutionDigest(ret, gas, failed || err != nil, state.GetLogs(txHash), execDB.(*recordingStateDB))
+				divergences = probe.diff(digest)
+			}
+			// Roll the call back if it exceeded the limit of the value transferred
+			if spending != nil {
+				limit := config.MaxValueTransferred.ToInt()
+				if transferred.Add(transferred, spending.value); transferred.Cmp(limit) > 0 {
+					state.RevertToSnapshot(callSnapshot)
+					limitErr = &ValueLimitError{Index: i, Limit: limit, Transferred: new(big.Int).Set(transferred)}
+				}
+			}
+		}
+		res := ExecutionResultArgs{
+			ReturnData: ret,
+			GasUsed:    hexutil.Uint64(gas),
+			Failed:     failed || err != nil,
+			Logs:       state.GetLogs(txHash),
+
+			EffectiveGasPrice: (*hexutil.Big)(msg.GasPrice()),
+
+			SubcallLimitExceeded: subcallsExceeded,
+
+			Nondeterministic: len(divergences) > 0,
+			Divergences:      divergences,
+		}
+		if cached != nil {
+			res = *cached
+			res.Memoized = true
+		}
+		// The batch is executed against an unlimited gas pool, so flag the calls
+		// which couldn't be included in a block.
+		res.ExceedsBlockGasLimit = gas > header.GasLimit
+		res.GasPolicyViolated = config.MaxGasPerCall > 0 && gas > uint64(config.MaxGasPerCall)
+		result.GasUsed += hexutil.Uint64(gas)
+		result.ExceedsBlockGasLimit = uint64(result.GasUsed) > header.GasLimit
+
+		if err != nil {
+			res.Err = newPrecheckError(i, msg, callDB, err)
+		} else if failure != nil {
+			res.Err = newCallError(i, ret, msg.Gas(), failure)
+		}
+		if limitErr != nil {
+			res.Failed, res.Err = true, limitErr
+		}
+		if config.RewriteInput != nil {
+			res.Input = msg.Data()
+		}
+		if msg.To() != nil && len(msg.Data()) >= 4 {
+			res.Selector = common.CopyBytes(msg.Data()[:4])
+		}
+		if config.EncodeTransactions && !res.Failed {
+			gasLimit := uint64(res.GasUsed)
+			if call.Gas != nil {
+				gasLimit = msg.Gas()
+			}
+			tx, err := encodeTransaction(nonce, msg, gasLimit)
+			if err != nil {
+				return nil, err
+			}
+			res.Transaction = tx
+		}
+		if res.Logs == nil {
+			res.Logs = []*types.Log{}
+		}
+		for _, l := range res.Logs {
+			l.BlockNumber = header.Number.Uint64()
+		}
+		if config.AccumulateLogs {
+			result.AllLogs = append(result.AllLogs, res.Logs...)
+		}
+		if config.GroupLogs {
+			res.LogsByAddress = make(map[common.Address][]*types.Log)
+			for _, l := range res.Logs {
+				res.LogsByAddress[l.Address] = append(res.LogsByAddress[l.Address], l)
+			}
+		}
+		for _, tracer := range tracers {
+			tracer.report(&res)
+		}
+		if res.Err != nil {
+			res.ErrorCode = errorCode(res.Err)
+		}
+		res.Balances = trackedBalances(state, config.TrackBalances)
+
+		// Memoized results carry the violations of the call they repeat, check
+		// the invariants afresh instead of adding to those
+		res.ViolatedInvariants = nil
+		for j, inv := range config.Invariants {
+			if !inv.holds(invariants[j], state.GetState(inv.Address, inv.Slot)) {
+				res.ViolatedInvariants = append(res.ViolatedInvariants, hexutil.Uint64(j))
+			}
+		}
+		if to := msg.To(); to != nil {
+			if p := activePrecompiles(rules)[*to]; p != nil {
+				res.Precompile = &PrecompileArgs{Address: *to, RequiredGas: hexutil.Uint64(p.RequiredGas(msg.Data()))}
+			}
+		}
+		if read != nil && cached == nil && read.version == versions.version && !res.Failed && len(res.Logs) == 0 {
+			reads[*read] = res
+		}
+		result.Calls = append(result.Calls, res)
+		if config.OnCallComplete != nil {
+			config.OnCallComplete(i, res)
+		}
+
+		state.Finalise(deleteEmpty)
+
+		// The exceeded limit fails the call, record it ahead of an atomic
+		// rollback which would otherwise end the batch first
+		if limitErr != nil {
+			exceededAt := hexutil.Uint64(i)
+			result.ValueLimitExceededAt = &exceededAt
+		}
+		if config.Atomic && res.Failed && !(config.ExpectedReverts[i] && isError(res.Err, vm.ErrExecutionReverted)) {
+			state = pre
+			revertedAt := hexutil.Uint64(i)
+			result.RevertedAt = &revertedAt
+			if config.AccumulateLogs {
+				result.AllLogs = []*types.Log{}
+			}
+			break
+		}
+		if limitErr != nil {
+			break
+		}
+	}
+	result.FinalBalances = trackedBalances(state, config.TrackBalances)
+
+	if witness != nil {
+		if result.Witness, err = witness.witness(header.Root, len(overrides) == 0 && config.TxIndex == nil); err != nil {
+			return nil, err
+		}
+	}
+	for k, i := range order {
+		if i < len(result.Calls) && outcomeDiffers(result.Calls[i], reordered[k]) {
+			result.Calls[i].OrderDependent = true
+			result.OrderDependent = true
+		}
+	}
+	if config.Branching {
+		// Conclude the batch, which a rolled back one never started
+		state.Finalise(deleteEmpty)
+		result.Branch = newMulticallBranch(b, state, header, chainConfig, hashes, vmCfg, config.Enforce3607, deleteEmpty, globalGasCap, len(result.Calls))
+	}
+	return result, nil
+}
+
+// Multicall executes the given calls sequentially on top of the state of the
+// given block number, every call observing the state changes made by the calls
+// before it.
+//
+// Additionally, the caller can specify a batch of contract for fields overriding
+// and configure the execution of the batch.
+//
+// Note, this function doesn't make any changes in the state/blockchain and is
+// useful to simulate a sequence of dependent transactions.
+func (s *PublicBlockChainAPI) Multicall(ctx context.Context, calls []MulticallArgs, blockNr rpc.BlockNumber, overrides *map[common.Address]account, config *MulticallConfig) (*MulticallResult, error) {
+	var accounts map[common.Address]account
+	if overrides != nil {
+		accounts = *overrides
+	}
+	var cfg MulticallConfig
+	if config != nil {
+		cfg = *config
+	}
+	result, err := DoMulticall(ctx, s.b, calls, blockNr, accounts, cfg, vm.Config{}, 5*time.Second, s.b.RPCGasCap())
+	if err != nil {
+		return nil, err
+	}
+	for i := range result.Calls {
+		if err := result.Calls[i].Err; err != nil {
+			result.Calls[i].Error = err.Error()
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,205 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// MulticallBranch is the state left behind by a multicall batch executed in
+// branching mode, which further calls can be executed on. Branches are forked
+// cheaply: a fork shares the state of the branch at the time of forking and
+// only records its own modifications on top, instead of copying the state.
+//
+// A branch and its forks share the state of the batch, so they must not be
+// used concurrently.
+type MulticallBranch struct {
+	b           Backend
+	base        *state.StateDB // State at the end of the batch, only read from
+	db          *cowState      // Modifications made on top of the batch
+	header      *types.Header
+	chainConfig *params.ChainConfig
+	hashes      map[uint64]common.Hash
+	vmCfg       vm.Config
+	enforce3607 bool // Whether calls from accounts with code are rejected
+	deleteEmpty bool
+	gasCap      *big.Int
+	next        int // Index of the next call executed on the branch
+}
+
+// newMulticallBranch creates a branch on top of the given state, which must not
+// be modified while the branch is in use.
+func newMulticallBranch(b Backend, base *state.StateDB, header *types.Header, chainConfig *params.ChainConfig, hashes map[uint64]common.Hash, vmCfg vm.Config, enforce3607 bool, deleteEmpty bool, gasCap *big.Int, next int) *MulticallBranch {
+	return &MulticallBranch{
+		b:           b,
+		base:        base,
+		db:          newCowState(base, newCowLayer(nil)),
+		header:      header,
+		chainConfig: chainConfig,
+		hashes:      hashes,
+		vmCfg:       vmCfg,
+		enforce3607: enforce3607,
+		deleteEmpty: deleteEmpty,
+		gasCap:      gasCap,
+		next:        next,
+	}
+}
+
+// Fork returns a new branch continuing from the current state of the branch.
+// Calls executed on either branch don't affect the other one.
+func (br *MulticallBranch) Fork() *MulticallBranch {
+	fork := *br
+	fork.db = br.db.fork()
+	return &fork
+}
+
+// Multicall executes the given calls sequentially on top of the branch, every
+// call observing the state changes made by the calls before it. Calls are
+// indexed in continuation of the batch the branch originates from.
+//
+// The options of the calls apply like within the batch, but only the plain
+// results of the calls are reported: the tracing options of the batch don't
+// apply to branches.
+func (br *MulticallBranch) Multicall(ctx context.Context, calls []MulticallArgs) ([]ExecutionResultArgs, error) {
+	// Cancel the EVMs once the calls have completed
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]ExecutionResultArgs, 0, len(calls))
+	for _, call := range calls {
+		i := br.next
+		br.next++
+
+		msg := call.toMessage(br.b, br.gasCap)
+		txHash := common.BigToHash(big.NewInt(int64(i)))
+		br.db.prepare(txHash, br.header.Hash(), i)
+
+		var (
+			ret     []byte
+			gas     uint64
+			failed  bool
+			failure error
+		)
+		callDB, callCfg, err := call.prepare(msg, br.db, br.vmCfg, br.enforce3607)
+		if err == nil {
+			evm, vmError, evmErr := newMulticallEVM(ctx, br.b, msg, br.base, callDB, br.header, br.chainConfig, br.hashes, callCfg)
+			if evmErr != nil {
+				return nil, evmErr
+			}
+			go func() {
+				<-ctx.Done()
+				evm.Cancel()
+			}()
+			ret, gas, failed, err = core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(math.MaxUint64))
+			if err := vmError(); err != nil {
+				return nil, err
+			}
+			if evm.Cancelled() {
+				return nil, fmt.Errorf("execution aborted: %v", ctx.Err())
+			}
+			failure = evm.Failure()
+		}
+		res := ExecutionResultArgs{
+			ReturnData: ret,
+			GasUsed:    hexutil.Uint64(gas),
+			Failed:     failed || err != nil,
+			Logs:       br.db.logs,
+
+			EffectiveGasPrice: (*hexutil.Big)(msg.GasPrice()),
+		}
+		if err != nil {
+			res.Err = newPrecheckError(i, msg, callDB, err)
+		} else if failure != nil {
+			res.Err = newCallError(i, ret, msg.Gas(), failure)
+		}
+		if res.Logs == nil {
+			res.Logs = []*types.Log{}
+		}
+		for _, l := range res.Logs {
+			l.BlockNumber = br.header.Number.Uint64()
+		}
+		if res.Err != nil {
+			res.ErrorCode = errorCode(res.Err)
+		}
+		results = append(results, res)
+
+		br.db.finalise(br.deleteEmpty)
+	}
+	return results, nil
+}
+
+// multicallPermutation validates the order to execute a batch of the given size
+// in for detecting order dependence, defaulting to the reverse order.
+func multicallPermutation(permutation []int, size int) ([]int, error) {
+	if permutation == nil {
+		order := make([]int, size)
+		for i := range order {
+			order[i] = size - 1 - i
+		}
+		return order, nil
+	}
+	if len(permutation) != size {
+		return nil, fmt.Errorf("permutation of %d calls for a batch of %d", len(permutation), size)
+	}
+	seen := make([]bool, size)
+	for _, i := range permutation {
+		if i < 0 || i >= size || seen[i] {
+			return nil, fmt.Errorf("invalid permutation %v", permutation)
+		}
+		seen[i] = true
+	}
+	return permutation, nil
+}
+
+// executeReordered executes the calls on the branch in the given order, which
+// the results are returned in. The branch is executed without any tracer.
+func executeReordered(ctx context.Context, br *MulticallBranch, calls []MulticallArgs, order []int) ([]ExecutionResultArgs, error) {
+	br.vmCfg.Debug, br.vmCfg.Tracer = false, nil
+
+	reordered := make([]MulticallArgs, len(order))
+	for k, i := range order {
+		reordered[k] = calls[i]
+	}
+	return br.Multicall(ctx, reordered)
+}
+
+// outcomeDiffers reports whether two executions of a call had different
+// outcomes, i.e. return data, gas usage, success or logs.
+func outcomeDiffers(a, b ExecutionResultArgs) bool {
+	if !bytes.Equal(a.ReturnData, b.ReturnData) || a.GasUsed != b.GasUsed || a.Failed != b.Failed || len(a.Logs) != len(b.Logs) {
+		return true
+	}
+	for i := range a.Logs {
+		if a.Logs[i].Address != b.Logs[i].Address || !reflect.DeepEqual(a.Logs[i].Topics, b.Logs[i].Topics) || !bytes.Equal(a.Logs[i].Data, b.Logs[i].Data) {
+			return true
+		}
+	}
+	return false
+}
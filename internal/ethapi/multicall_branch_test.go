@@ -0,0 +1,223 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// branchMulticall executes the given calls on the branch, failing the test on
+// error.
+func branchMulticall(t testing.TB, br *MulticallBranch, calls ...MulticallArgs) []ExecutionResultArgs {
+	results, err := br.Multicall(context.Background(), calls)
+	if err != nil {
+		t.Fatalf("branch multicall failed: %v", err)
+	}
+	return results
+}
+
+// storeCall returns a call storing the given value with storeOrRevertCode.
+func storeCall(value byte) MulticallArgs {
+	return newCall(multicallContract, common.LeftPadBytes([]byte{value}, 32))
+}
+
+func TestMulticallBranchIsolation(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+	b.state.SetBalance(multicallSender, big.NewInt(1000))
+
+	destructing := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	b.state.SetCode(destructing, []byte{byte(vm.PUSH1), 0x00, byte(vm.SELFDESTRUCT)})
+
+	result := b.multicall(t, []MulticallArgs{storeCall(1), storeCall(2)}, MulticallConfig{Branching: true})
+	br := result.Branch
+	if br == nil {
+		t.Fatalf("no branch returned")
+	}
+	first, second := br.Fork(), br.Fork()
+
+	// Modify every branch differently
+	recipient := common.HexToAddress("0x4000000000000000000000000000000000000004")
+	transfer := newCall(recipient, nil)
+	transfer.Value = (*hexutil.Big)(big.NewInt(100))
+
+	branchMulticall(t, br, storeCall(3))
+	branchMulticall(t, first, storeCall(4), newCall(destructing, nil))
+	branchMulticall(t, second, storeCall(5), transfer)
+
+	nested := first.Fork()
+	branchMulticall(t, nested, storeCall(6))
+
+	tests := []struct {
+		name       string
+		db         vm.StateDB
+		slot       byte
+		destructed bool
+		received   int64
+	}{
+		{"batch", b.state, 2, false, 0},
+		{"branch", br.db, 3, false, 0},
+		{"first", first.db, 4, true, 0},
+		{"second", second.db, 5, false, 100},
+		{"nested", nested.db, 6, true, 0},
+	}
+	for _, tt := range tests {
+		if have := tt.db.GetState(multicallContract, common.Hash{}); have != common.BytesToHash([]byte{tt.slot}) {
+			t.Errorf("%s: slot mismatch: have %x, want %d", tt.name, have, tt.slot)
+		}
+		if tt.db.Exist(destructing) == tt.destructed {
+			t.Errorf("%s: destructed contract existence mismatch", tt.name)
+		}
+		if have := tt.db.GetBalance(recipient); have.Cmp(big.NewInt(tt.received)) != 0 {
+			t.Errorf("%s: recipient balance mismatch: have %v, want %d", tt.name, have, tt.received)
+		}
+	}
+	// Calls are indexed in continuation of the batch and reverts are contained
+	results := branchMulticall(t, second, storeCall(0))
+	if revert, ok := results[0].Err.(*CallRevertError); !ok || revert.Index != 4 {
+		t.Errorf("revert mismatch: %v", results[0].Err)
+	}
+	if have := second.db.GetState(multicallContract, common.Hash{}); have != common.BytesToHash([]byte{5}) {
+		t.Errorf("reverted call modified the branch: slot %x", have)
+	}
+}
+
+func TestMulticallBranchRecreation(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// The contract is deployed with a storage slot set, which is wiped when it
+	// self-destructs, also after forking
+	contract := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	b.state.SetCode(contract, []byte{byte(vm.PUSH1), 0x00, byte(vm.SELFDESTRUCT)})
+	b.state.SetState(contract, common.Hash{1}, common.Hash{2})
+
+	result := b.multicall(t, nil, MulticallConfig{Branching: true})
+	fork := result.Branch.Fork()
+	branchMulticall(t, fork, newCall(contract, nil))
+
+	if fork.db.Exist(contract) || fork.db.GetState(contract, common.Hash{1}) != (common.Hash{}) {
+		t.Errorf("destructed contract still present in fork")
+	}
+	if have := result.Branch.db.GetState(contract, common.Hash{1}); have != (common.Hash{2}) {
+		t.Errorf("destruction leaked into the parent branch: slot %x", have)
+	}
+	// Recreating the account in a later fork doesn't revive the storage
+	recreated := fork.Fork()
+	recreated.db.AddBalance(contract, big.NewInt(1))
+	recreated.db.finalise(false)
+	if !recreated.db.Exist(contract) || recreated.db.GetState(contract, common.Hash{1}) != (common.Hash{}) {
+		t.Errorf("recreated account mismatch")
+	}
+}
+
+// benchmarkMulticallBranches executes a common prefix of calls and then a call
+// on each of 100 states branching off the prefix, which are obtained by branch.
+func benchmarkMulticallBranches(b *testing.B, branch func(backend *multicallBackend, calls []MulticallArgs)) {
+	backend := newMulticallBackend(b)
+	for i := 0; i < 1000; i++ {
+		addr := common.BigToAddress(big.NewInt(int64(0x10000 + i)))
+		backend.state.SetBalance(addr, big.NewInt(1))
+		backend.state.SetState(addr, common.Hash{}, common.Hash{1})
+	}
+	backend.state.SetCode(multicallContract, storeOrRevertCode)
+	seeded := backend.state.Copy()
+
+	var prefix []MulticallArgs
+	for i := 1; i <= 10; i++ {
+		prefix = append(prefix, storeCall(byte(i)))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		backend.state = seeded.Copy()
+		branch(backend, prefix)
+	}
+}
+
+func BenchmarkMulticallBranches(b *testing.B) {
+	b.Run("fork", func(b *testing.B) {
+		benchmarkMulticallBranches(b, func(backend *multicallBackend, prefix []MulticallArgs) {
+			result := backend.multicall(b, prefix, MulticallConfig{Branching: true})
+			for i := 0; i < 100; i++ {
+				branchMulticall(b, result.Branch.Fork(), storeCall(byte(i+11)))
+			}
+		})
+	})
+	b.Run("copy", func(b *testing.B) {
+		benchmarkMulticallBranches(b, func(backend *multicallBackend, prefix []MulticallArgs) {
+			backend.multicall(b, prefix, MulticallConfig{})
+			base := backend.state
+			for i := 0; i < 100; i++ {
+				backend.state = base.Copy()
+				if _, err := DoMulticall(context.Background(), backend, []MulticallArgs{storeCall(byte(i + 11))}, rpc.LatestBlockNumber, nil, MulticallConfig{}, vm.Config{}, 0, nil); err != nil {
+					b.Fatalf("multicall failed: %v", err)
+				}
+			}
+		})
+	})
+}
+
+func TestMulticallOrderDependence(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+	other := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	b.state.SetCode(other, storeOrRevertCode)
+
+	// Writes to the same slot are order dependent, as the first write is more
+	// expensive than the second one
+	config := MulticallConfig{DetectOrderDependence: true}
+	result := b.multicall(t, []MulticallArgs{storeCall(1), storeCall(2)}, config)
+	if !result.OrderDependent || !result.Calls[0].OrderDependent || !result.Calls[1].OrderDependent {
+		t.Errorf("conflicting calls not flagged")
+	}
+	// Writes to different contracts are independent
+	b = newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+	b.state.SetCode(other, storeOrRevertCode)
+
+	calls := []MulticallArgs{storeCall(1), newCall(other, common.LeftPadBytes([]byte{2}, 32))}
+	result = b.multicall(t, calls, config)
+	if result.OrderDependent || result.Calls[0].OrderDependent || result.Calls[1].OrderDependent {
+		t.Errorf("independent calls flagged")
+	}
+	// Reordering doesn't affect the batch itself
+	if have := b.state.GetState(other, common.Hash{}); have != common.BytesToHash([]byte{2}) {
+		t.Errorf("slot mismatch: have %x, want 2", have)
+	}
+	// The options of the calls apply to the reordered execution too
+	eoa := newCall(other, common.LeftPadBytes([]byte{0}, 32))
+	eoa.AsEOA = []common.Address{other}
+
+	result = b.multicall(t, []MulticallArgs{storeCall(3), eoa}, config)
+	if result.Calls[1].Failed || result.OrderDependent {
+		t.Errorf("calls with options flagged: failed %v, order dependent %v", result.Calls[1].Failed, result.OrderDependent)
+	}
+	// Permutations must cover every call exactly once
+	for _, permutation := range [][]int{{0}, {0, 0}, {0, 2}} {
+		config := MulticallConfig{DetectOrderDependence: true, Permutation: permutation}
+		if _, err := DoMulticall(context.Background(), b, calls, rpc.LatestBlockNumber, nil, config, vm.Config{}, 0, nil); err == nil {
+			t.Errorf("invalid permutation %v accepted", permutation)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// cowAccount is the state of an account within a cowLayer. Accounts are never
+// modified in place, modifications replace them with updated copies.
+type cowAccount struct {
+	balance  *big.Int
+	nonce    uint64
+	code     []byte
+	codeHash common.Hash
+	suicided bool
+
+	deleted bool // Whether the account was deleted, hiding it in lower layers
+	fresh   bool // Whether the account was (re)created, hiding its storage in lower layers
+}
+
+// empty returns whether the account is empty as defined by EIP-161.
+func (acc *cowAccount) empty() bool {
+	return acc.nonce == 0 && acc.balance.Sign() == 0 && acc.codeHash == emptyCodeHash
+}
+
+// cowLayer is a set of state modifications on top of a parent layer, or the
+// base state if there is none. A layer is frozen once it has been forked, as
+// it is shared by multiple states from then on.
+type cowLayer struct {
+	parent   *cowLayer
+	accounts map[common.Address]*cowAccount
+	storage  map[common.Address]map[common.Hash]common.Hash
+}
+
+func newCowLayer(parent *cowLayer) *cowLayer {
+	return &cowLayer{
+		parent:   parent,
+		accounts: make(map[common.Address]*cowAccount),
+		storage:  make(map[common.Address]map[common.Hash]common.Hash),
+	}
+}
+
+// cowState is a copy-on-write state database on top of a read-only base state.
+// Modifications are recorded in a stack of layers, so forking the state only
+// takes a new layer on top of the shared ones instead of copying the state.
+//
+// The state implements vm.StateDB, transactions are separated by prepare and
+// finalise. It is not safe for concurrent use, neither are states forked from
+// each other, as they share the base state.
+type cowState struct {
+	base  *state.StateDB
+	layer *cowLayer
+
+	journal []func() // Reverts the modifications of the current transaction
+
+	dirties map[common.Address]struct{}                    // Accounts modified by the current transaction
+	origins map[common.Address]map[common.Hash]common.Hash // Storage values before the current transaction
+	created map[common.Address]struct{}                    // Accounts created by the current transaction
+	refund  uint64
+
+	thash, bhash common.Hash
+	txIndex      int
+	logs         []*types.Log // Logs emitted by the current transaction
+	logSize      uint
+}
+
+func newCowState(base *state.StateDB, layer *cowLayer) *cowState {
+	return &cowState{
+		base:    base,
+		layer:   layer,
+		dirties: make(map[common.Address]struct{}),
+		origins: make(map[common.Address]map[common.Hash]common.Hash),
+		created: make(map[common.Address]struct{}),
+	}
+}
+
+// fork freezes the modifications made so far and returns a new state sharing
+// them, with both states recording further modifications in layers of their
+// own. It must only be called in between transactions.
+func (s *cowState) fork() *cowState {
+	// Share the parent of the current layer instead if nothing was modified
+	// since the last fork, keeping the stack of layers short.
+	frozen := s.layer
+	if len(frozen.accounts) == 0 && len(frozen.storage) == 0 {
+		frozen = frozen.parent
+	} else {
+		s.layer = newCowLayer(frozen)
+	}
+	fork := newCowState(s.base, newCowLayer(frozen))
+	fork.logSize = s.logSize
+	return fork
+}
+
+// prepare sets the transaction the following modifications belong to.
+func (s *cowState) prepare(thash, bhash common.Hash, ti int) {
+	s.thash, s.bhash, s.txIndex = thash, bhash, ti
+	s.logs = nil
+}
+
+// finalise concludes the current transaction, deleting the accounts which
+// self-destructed and, if deleteEmpty is set, the ones left empty.
+func (s *cowState) finalise(deleteEmpty bool) {
+	for addr := range s.dirties {
+		acc := s.account(addr)
+		if acc != nil && (acc.suicided || (deleteEmpty && acc.empty())) {
+			s.layer.accounts[addr] = &cowAccount{deleted: true}
+			delete(s.layer.storage, addr)
+		}
+	}
+	s.journal = nil
+	s.dirties = make(map[common.Address]struct{})
+	s.origins = make(map[common.Address]map[common.Hash]common.Hash)
+	s.created = make(map[common.Address]struct{})
+	s.refund = 0
+}
+
+// account retrieves the current state of an account, or nil if it doesn't
+// exist. The returned account must not be modified.
+func (s *cowState) account(addr common.Address) *cowAccount {
+	for l := s.layer; l != nil; l = l.parent {
+		if acc, ok := l.accounts[addr]; ok {
+			if acc.deleted {
+				return nil
+			}
+			return acc
+		}
+	}
+	if !s.base.Exist(addr) {
+		return nil
+	}
+	return &cowAccount{
+		balance:  s.base.GetBalance(addr),
+		nonce:    s.base.GetNonce(addr),
+		code:     s.base.GetCode(addr),
+		codeHash: s.base.GetCodeHash(addr),
+	}
+}
+
+// update modifies a copy of an account in the current layer, creating the
+// account if it doesn't exist.
+func (s *cowState) update(addr common.Address, fn func(acc *cowAccount)) {
+	prev, had := s.layer.accounts[addr]
+
+	acc := &cowAccount{balance: new(big.Int), codeHash: emptyCodeHash, fresh: true}
+	if cur := s.account(addr); cur != nil {
+		*acc = *cur
+		// The account's storage in lower layers stays visible unless it was
+		// recreated in this very layer
+		acc.fresh = had && prev.fresh
+	}
+	fn(acc)
+
+	s.layer.accounts[addr] = acc
+	s.dirties[addr] = struct{}{}
+	s.journal = append(s.journal, func() {
+		if had {
+			s.layer.accounts[addr] = prev
+		} else {
+			delete(s.layer.accounts, addr)
+		}
+	})
+}
+
+func (s *cowState) CreateAccount(addr common.Address) {
+	balance := new(big.Int)
+	if acc := s.account(addr); acc != nil {
+		balance = acc.balance
+	}
+	storage, hadStorage := s.layer.storage[addr]
+	_, wasCreated := s.created[addr]
+
+	delete(s.layer.storage, addr)
+	s.created[addr] = struct{}{}
+	s.journal = append(s.journal, func() {
+		if hadStorage {
+			s.layer.storage[addr] = storage
+		}
+		if !wasCreated {
+			delete(s.created, addr)
+		}
+	})
+	s.update(addr, func(acc *cowAccount) {
+		*acc = cowAccount{balance: balance, codeHash: emptyCodeHash, fresh: true}
+	})
+}
+
+func (s *cowState) SubBalance(addr common.Address, amount *big.Int) {
+	s.update(addr, func(acc *cowAccount) {
+		acc.balance = new(big.Int).Sub(acc.balance, amount)
+	})
+}
+
+func (s *cowState) AddBalance(addr common.Address, amount *big.Int) {
+	s.update(addr, func(acc *cowAccount) {
+		acc.balance = new(big.Int).Add(acc.balance, amount)
+	})
+}
+
+func (s *cowState) GetBalance(addr common.Address) *big.Int {
+	if acc := s.account(addr); acc != nil {
+		return acc.balance
+	}
+	return common.Big0
+}
+
+func (s *cowState) GetNonce(addr common.Address) uint64 {
+	if acc := s.account(addr); acc != nil {
+		return acc.nonce
+	}
+	return 0
+}
+
+func (s *cowState) SetNonce(addr common.Address, nonce uint64) {
+	s.update(addr, func(acc *cowAccount) {
+		acc.nonce = nonce
+	})
+}
+
+func (s *cowState) GetCodeHash(addr common.Address) common.Hash {
+	if acc := s.account(addr); acc != nil {
+		return acc.codeHash
+	}
+	return common.Hash{}
+}
+
+func (s *cowState) GetCode(addr common.Address) []byte {
+	if acc := s.account(addr); acc != nil {
+		return acc.code
+	}
+	return nil
+}
+
+func (s *cowState) SetCode(addr common.Address, code []byte) {
+	s.update(addr, func(acc *cowAccount) {
+		acc.code, acc.codeHash = code, crypto.Keccak256Hash(code)
+	})
+}
+
+func (s *cowState) GetCodeSize(addr common.Address) int {
+	return len(s.GetCode(addr))
+}
+
+func (s *cowState) AddRefund(gas uint64) {
+	prev := s.refund
+	s.refund += gas
+	s.journal = append(s.journal, func() { s.refund = prev })
+}
+
+func (s *cowState) SubRefund(gas uint64) {
+	if gas > s.refund {
+		panic(fmt.Sprintf("refund counter below zero (gas: %d > refund: %d)", gas, s.refund))
+	}
+	prev := s.refund
+	s.refund -= gas
+	s.journal = append(s.journal, func() { s.refund = prev })
+}
+
+func (s *cowState) GetRefund() uint64 {
+	return s.refund
+}
+
+func (s *cowState) GetCommittedState(addr common.Address, key common.Hash) common.Hash {
+	if _, ok := s.created[addr]; ok {
+		return common.Hash{}
+	}
+	if value, ok := s.origins[addr][key]; ok {
+		return value
+	}
+	return s.GetState(addr, key)
+}
+
+func (s *cowState) GetState(addr common.Address, key common.Hash) common.Hash {
+	for l := s.layer; l != nil; l = l.parent {
+		if value, ok := l.storage[addr][key]; ok {
+			return value
+		}
+		if acc, ok := l.accounts[addr]; ok && (acc.deleted || acc.fresh) {
+			return common.Hash{}
+		}
+	}
+	return s.base.GetState(addr, key)
+}
+
+func (s *cowState) SetState(addr common.Address, key, value common.Hash) {
+	// Track the value the slot had before the transaction, unless the account
+	// was created by it, in which case the slot had none
+	if _, ok := s.created[addr]; !ok {
+		origins := s.origins[addr]
+		if origins == nil {
+			origins = make(map[common.Hash]common.Hash)
+			s.origins[addr] = origins
+		}
+		if _, ok := origins[key]; !ok {
+			origins[key] = s.GetState(addr, key)
+		}
+	}
+	s.update(addr, func(*cowAccount) {})
+
+	storage := s.layer.storage[addr]
+	if storage == nil {
+		storage = make(map[common.Hash]common.Hash)
+		s.layer.storage[addr] = storage
+	}
+	prev, had := storage[key]
+	storage[key] = value
+	s.journal = append(s.journal, func() {
+		if had {
+			storage[key] = prev
+		} else {
+			delete(storage, key)
+		}
+	})
+}
+
+func (s *cowState) Suicide(addr common.Address) bool {
+	if s.account(addr) == nil {
+		return false
+	}
+	s.update(addr, func(acc *cowAccount) {
+		acc.suicided, acc.balance = true, new(big.Int)
+	})
+	return true
+}
+
+func (s *cowState) HasSuicided(addr common.Address) bool {
+	acc := s.account(addr)
+	return acc != nil && acc.suicided
+}
+
+func (s *cowState) Exist(addr common.Address) bool {
+	return s.account(addr) != nil
+}
+
+func (s *cowState) Empty(addr common.Address) bool {
+	acc := s.account(addr)
+	return acc == nil || acc.empty()
+}
+
+func (s *cowState) RevertToSnapshot(id int) {
+	for i := len(s.journal) - 1; i >= id; i-- {
+		s.journal[i]()
+	}
+	s.journal = s.journal[:id]
+}
+
+func (s *cowState) Snapshot() int {
+	return len(s.journal)
+}
+
+func (s *cowState) AddLog(log *types.Log) {
+	log.TxHash, log.BlockHash, log.TxIndex = s.thash, s.bhash, uint(s.txIndex)
+	log.Index = s.logSize
+
+	s.logs = append(s.logs, log)
+	s.logSize++
+	s.journal = append(s.journal, func() {
+		s.logs = s.logs[:len(s.logs)-1]
+		s.logSize--
+	})
+}
+
+func (s *cowState) AddPreimage(hash common.Hash, preimage []byte) {}
+
+func (s *cowState) ForEachStorage(addr common.Address, cb func(key, value common.Hash) bool) error {
+	seen := make(map[common.Hash]bool)
+	for l := s.layer; l != nil; l = l.parent {
+		for key, value := range l.storage[addr] {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if !cb(key, value) {
+				return nil
+			}
+		}
+		if acc, ok := l.accounts[addr]; ok && (acc.deleted || acc.fresh) {
+			return nil
+		}
+	}
+	return s.base.ForEachStorage(addr, func(key, value common.Hash) bool {
+		return seen[key] || cb(key, value)
+	})
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// recordingStateDB records the modifications made to the state through it, in
+// order, to compare the effects of executions.
+type recordingStateDB struct {
+	vm.StateDB
+	writes bytes.Buffer
+}
+
+func newRecordingStateDB(db vm.StateDB) *recordingStateDB {
+	return &recordingStateDB{StateDB: db}
+}
+
+func (db *recordingStateDB) record(format string, args ...interface{}) {
+	fmt.Fprintf(&db.writes, format+"\n", args...)
+}
+
+func (db *recordingStateDB) CreateAccount(addr common.Address) {
+	db.record("create %x", addr)
+	db.StateDB.CreateAccount(addr)
+}
+
+func (db *recordingStateDB) SubBalance(addr common.Address, amount *big.Int) {
+	db.record("sub %x %v", addr, amount)
+	db.StateDB.SubBalance(addr, amount)
+}
+
+func (db *recordingStateDB) AddBalance(addr common.Address, amount *big.Int) {
+	db.record("add %x %v", addr, amount)
+	db.StateDB.AddBalance(addr, amount)
+}
+
+func (db *recordingStateDB) SetNonce(addr common.Address, nonce uint64) {
+	db.record("nonce %x %d", addr, nonce)
+	db.StateDB.SetNonce(addr, nonce)
+}
+
+func (db *recordingStateDB) SetCode(addr common.Address, code []byte) {
+	db.record("code %x %x", addr, code)
+	db.StateDB.SetCode(addr, code)
+}
+
+func (db *recordingStateDB) SetState(addr common.Address, key, value common.Hash) {
+	db.record("store %x %x %x", addr, key, value)
+	db.StateDB.SetState(addr, key, value)
+}
+
+func (db *recordingStateDB) Suicide(addr common.Address) bool {
+	db.record("suicide %x", addr)
+	return db.StateDB.Suicide(addr)
+}
+
+func (db *recordingStateDB) RevertToSnapshot(id int) {
+	db.record("revert %d", id)
+	db.StateDB.RevertToSnapshot(id)
+}
+
+// executionDigest summarises the effects of executing a call.
+type executionDigest struct {
+	ret    []byte
+	gas    uint64
+	failed bool
+	logs   common.Hash // Hash of the consensus fields of the logs emitted
+	writes common.Hash // Hash of the state modifications made, in order
+}
+
+func newExecutionDigest(ret []byte, gas uint64, failed bool, logs []*types.Log, db *recordingStateDB) *executionDigest {
+	enc, _ := rlp.EncodeToBytes(logs)
+	return &executionDigest{
+		ret:    common.CopyBytes(ret),
+		gas:    gas,
+		failed: failed,
+		logs:   crypto.Keccak256Hash(enc),
+		writes: crypto.Keccak256Hash(db.writes.Bytes()),
+	}
+}
+
+// diff returns the aspects in which the executions summarised differ.
+func (d *executionDigest) diff(other *executionDigest) []string {
+	var diffs []string
+	if !bytes.Equal(d.ret, other.ret) {
+		diffs = append(diffs, "returnData")
+	}
+	if d.gas != other.gas {
+		diffs = append(diffs, "gasUsed")
+	}
+	if d.failed != other.failed {
+		diffs = append(diffs, "failed")
+	}
+	if d.logs != other.logs {
+		diffs = append(diffs, "logs")
+	}
+	if d.writes != other.writes {
+		diffs = append(diffs, "state")
+	}
+	return diffs
+}
+
+// probeCall executes a call on top of the multicall state and rolls its effects
+// back, summarising them to compare a repeated execution of the call against.
+//
+// The effects are rolled back through db, so the state databases wrapping the
+// state observe the rollback as well.
+func probeCall(ctx context.Context, b Backend, msg core.Message, state *state.StateDB, db vm.StateDB, header *types.Header, chainConfig *params.ChainConfig, hashes map[uint64]common.Hash, vmCfg vm.Config, txHash common.Hash) (*executionDigest, error) {
+	snapshot := db.Snapshot()
+	defer db.RevertToSnapshot(snapshot)
+
+	recorder := newRecordingStateDB(db)
+	evm, vmError, err := newMulticallEVM(ctx, b, msg, state, recorder, header, chainConfig, hashes, vmCfg)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		evm.Cancel()
+	}()
+	ret, gas, failed, err := core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(math.MaxUint64))
+	if err := vmError(); err != nil {
+		return nil, err
+	}
+	if evm.Cancelled() {
+		return nil, fmt.Errorf("execution aborted: %v", ctx.Err())
+	}
+	return newExecutionDigest(ret, gas, failed || err != nil, state.GetLogs(txHash), recorder), nil
+}
@@ -0,0 +1,243 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// revertSelector is the selector of Error(string), which Solidity encodes the
+// reasons of reverts with.
+var revertSelector = crypto.Keccak256([]byte("Error(string)"))[:4]
+
+// CallRevertError is the error of a multicall call which reverted.
+type CallRevertError struct {
+	Index  int           // Index of the call within the batch
+	Data   hexutil.Bytes // Data the call reverted with
+	Reason string        // Revert reason decoded from the data, if any
+}
+
+func (e *CallRevertError) Error() string {
+	if e.Reason == "" {
+		return "execution reverted"
+	}
+	return fmt.Sprintf("execution reverted: %v", e.Reason)
+}
+
+func (e *CallRevertError) Unwrap() error { return vm.ErrExecutionReverted }
+
+// CallOutOfGasError is the error of a multicall call which ran out of gas.
+type CallOutOfGasError struct {
+	Index int    // Index of the call within the batch
+	Gas   uint64 // Gas limit of the call
+	Err   error  // Underlying out of gas error
+}
+
+func (e *CallOutOfGasError) Error() string {
+	return fmt.Sprintf("%v (gas limit %d)", e.Err, e.Gas)
+}
+
+func (e *CallOutOfGasError) Unwrap() error { return e.Err }
+
+// CallError is the error of a multicall call which failed for any other reason,
+// either during execution or before it even started.
+type CallError struct {
+	Index int   // Index of the call within the batch
+	Err   error // Underlying failure
+}
+
+func (e *CallError) Error() string { return e.Err.Error() }
+
+func (e *CallError) Unwrap() error { return e.Err }
+
+// NonceError is the error of a multicall call whose nonce didn't match the
+// nonce of the sender.
+type NonceError struct {
+	Index    int            // Index of the call within the batch
+	Sender   common.Address // Sender of the call
+	Expected uint64         // Nonce of the sender in the state
+	Provided uint64         // Nonce of the call
+}
+
+func (e *NonceError) Error() string {
+	return fmt.Sprintf("%v: address %v, nonce %d, expected %d", e.Unwrap(), e.Sender.Hex(), e.Provided, e.Expected)
+}
+
+// Unwrap returns core.ErrNonceTooLow or core.ErrNonceTooHigh.
+func (e *NonceError) Unwrap() error {
+	if e.Provided < e.Expected {
+		return core.ErrNonceTooLow
+	}
+	return core.ErrNonceTooHigh
+}
+
+// errValueLimitExceeded is wrapped by the errors of calls which exceeded the
+// limit of the value transferred by a batch.
+var errValueLimitExceeded = errors.New("value limit exceeded")
+
+// ValueLimitError is the error of a multicall call which exceeded the limit of
+// the value transferred by the batch.
+type ValueLimitError struct {
+	Index       int      // Index of the call within the batch
+	Limit       *big.Int // Value the calls may transfer in total
+	Transferred *big.Int // Value transferred by the calls up to and including this one
+}
+
+func (e *ValueLimitError) Error() string {
+	return fmt.Sprintf("%v: transferred %v, limit %v", errValueLimitExceeded, e.Transferred, e.Limit)
+}
+
+func (e *ValueLimitError) Unwrap() error { return errValueLimitExceeded }
+
+// detailedError annotates a known error with details, e.g. the address it
+// applies to, while still unwrapping to the known error.
+type detailedError struct {
+	err    error
+	detail string
+}
+
+func (e *detailedError) Error() string { return fmt.Sprintf("%v: %v", e.err, e.detail) }
+
+func (e *detailedError) Unwrap() error { return e.err }
+
+// StateUnavailableError is returned if the state a multicall batch is to be
+// executed on can't be retrieved.
+type StateUnavailableError struct {
+	BlockNr rpc.BlockNumber
+	Err     error // Error retrieving the state, nil if it's simply missing
+}
+
+func (e *StateUnavailableError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("state of block %d unavailable", e.BlockNr)
+	}
+	return fmt.Sprintf("state of block %d unavailable: %v", e.BlockNr, e.Err)
+}
+
+func (e *StateUnavailableError) Unwrap() error { return e.Err }
+
+// newCallError classifies the failure of the call at the given index based on
+// the error the EVM aborted its execution with.
+func newCallError(index int, ret []byte, gas uint64, err error) error {
+	switch err {
+	case vm.ErrExecutionReverted:
+		reason, _ := unpackRevertReason(ret)
+		return &CallRevertError{Index: index, Data: common.CopyBytes(ret), Reason: reason}
+	case vm.ErrOutOfGas, vm.ErrCodeStoreOutOfGas:
+		return &CallOutOfGasError{Index: index, Gas: gas, Err: err}
+	default:
+		return &CallError{Index: index, Err: err}
+	}
+}
+
+// newPrecheckError wraps the error a call failed with before its execution
+// started, such as a mismatching nonce.
+func newPrecheckError(index int, msg core.Message, db vm.StateDB, err error) error {
+	if err == core.ErrNonceTooLow || err == core.ErrNonceTooHigh {
+		return &NonceError{Index: index, Sender: msg.From(), Expected: db.GetNonce(msg.From()), Provided: msg.Nonce()}
+	}
+	return &CallError{Index: index, Err: err}
+}
+
+// unwrapError returns the error wrapped by err, or nil if it doesn't wrap any.
+func unwrapError(err error) error {
+	if wrapper, ok := err.(interface{ Unwrap() error }); ok {
+		return wrapper.Unwrap()
+	}
+	return nil
+}
+
+// isError reports whether err is target or wraps it at any depth.
+func isError(err, target error) bool {
+	for ; err != nil; err = unwrapError(err) {
+		if err == target {
+			return true
+		}
+	}
+	return false
+}
+
+// errorCodes are the canonical names of the known errors calls may fail with,
+// which are stable across changes to the error messages.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{vm.ErrExecutionReverted, "EXECUTION_REVERTED"},
+	{vm.ErrOutOfGas, "OUT_OF_GAS"},
+	{vm.ErrCodeStoreOutOfGas, "CODE_STORE_OUT_OF_GAS"},
+	{vm.ErrDepth, "MAX_CALL_DEPTH_EXCEEDED"},
+	{vm.ErrInsufficientBalance, "INSUFFICIENT_BALANCE"},
+	{vm.ErrContractAddressCollision, "CONTRACT_ADDRESS_COLLISION"},
+	{vm.ErrWriteProtection, "WRITE_PROTECTION"},
+	{vm.ErrReturnDataOutOfBounds, "RETURN_DATA_OUT_OF_BOUNDS"},
+	{vm.ErrMaxCodeSizeExceeded, "MAX_CODE_SIZE_EXCEEDED"},
+	{vm.ErrInvalidJump, "INVALID_JUMP"},
+	{vm.ErrGasUintOverflow, "GAS_UINT_OVERFLOW"},
+	{vm.ErrReturnDataLimitExceeded, "RETURN_DATA_LIMIT_EXCEEDED"},
+	{vm.ErrSubcallLimitExceeded, "SUBCALL_LIMIT_EXCEEDED"},
+	{errSenderNoEOA, "SENDER_NOT_EOA"},
+	{core.ErrNonceTooLow, "NONCE_TOO_LOW"},
+	{core.ErrNonceTooHigh, "NONCE_TOO_HIGH"},
+	{errValueLimitExceeded, "VALUE_LIMIT_EXCEEDED"},
+}
+
+// errorCode returns the canonical name of the error a call failed with, or an
+// empty string if the error isn't a known one.
+func errorCode(err error) string {
+	for ; err != nil; err = unwrapError(err) {
+		for _, known := range errorCodes {
+			if err == known.err {
+				return known.code
+			}
+		}
+		switch err.(type) {
+		case *vm.ErrStackUnderflow:
+			return "STACK_UNDERFLOW"
+		case *vm.ErrStackOverflow:
+			return "STACK_OVERFLOW"
+		case *vm.ErrInvalidOpCode:
+			return "INVALID_OPCODE"
+		}
+	}
+	return ""
+}
+
+// unpackRevertReason decodes the reason of a revert from the data returned by
+// the reverting call, if it was encoded as an Error(string).
+func unpackRevertReason(data []byte) (string, bool) {
+	if len(data) < 4 || !bytes.Equal(data[:4], revertSelector) {
+		return "", false
+	}
+	typ, _ := abi.NewType("string", nil)
+	var reason string
+	if err := (abi.Arguments{{Type: typ}}).Unpack(&reason, data[4:]); err != nil {
+		return "", false
+	}
+	return reason, true
+}
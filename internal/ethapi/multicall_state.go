@@ -0,0 +1,296 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// CodeResolver retrieves the code of an account which has none in the local
+// state, e.g. from a remote node.
+type CodeResolver func(addr common.Address) ([]byte, error)
+
+// resolvingStateDB is a state database lazily loading the code of accounts
+// through a code resolver upon their first access.
+//
+// Resolved code is considered immutable for the lifetime of the database: if
+// the state changes it is reverted, the code is reinstated upon next access.
+type resolvingStateDB struct {
+	*state.StateDB
+
+	resolver CodeResolver
+	resolved map[common.Address][]byte // Resolved code, nil if the state had code
+	err      error                     // First error returned by the resolver
+}
+
+func newResolvingStateDB(statedb *state.StateDB, resolver CodeResolver) *resolvingStateDB {
+	return &resolvingStateDB{
+		StateDB:  statedb,
+		resolver: resolver,
+		resolved: make(map[common.Address][]byte),
+	}
+}
+
+// resolve ensures the code of the account is loaded into the state, consulting
+// the resolver the first time an account without code is accessed.
+func (db *resolvingStateDB) resolve(addr common.Address) {
+	code, ok := db.resolved[addr]
+	if !ok {
+		if db.StateDB.GetCodeSize(addr) == 0 {
+			var err error
+			if code, err = db.resolver(addr); err != nil && db.err == nil {
+				db.err = fmt.Errorf("failed to resolve code of %x: %v", addr, err)
+			}
+		}
+		db.resolved[addr] = code
+	}
+	if len(code) > 0 && db.StateDB.GetCodeSize(addr) == 0 && !db.StateDB.HasSuicided(addr) {
+		db.StateDB.SetCode(addr, code)
+	}
+}
+
+func (db *resolvingStateDB) GetCode(addr common.Address) []byte {
+	db.resolve(addr)
+	return db.StateDB.GetCode(addr)
+}
+
+func (db *resolvingStateDB) GetCodeSize(addr common.Address) int {
+	db.resolve(addr)
+	return db.StateDB.GetCodeSize(addr)
+}
+
+func (db *resolvingStateDB) GetCodeHash(addr common.Address) common.Hash {
+	db.resolve(addr)
+	return db.StateDB.GetCodeHash(addr)
+}
+
+func (db *resolvingStateDB) Exist(addr common.Address) bool {
+	db.resolve(addr)
+	return db.StateDB.Exist(addr)
+}
+
+func (db *resolvingStateDB) Empty(addr common.Address) bool {
+	db.resolve(addr)
+	return db.StateDB.Empty(addr)
+}
+
+// Error returns the first error encountered while resolving code, or the error
+// of the underlying state.
+func (db *resolvingStateDB) Error() error {
+	if db.err != nil {
+		return db.err
+	}
+	return db.StateDB.Error()
+}
+
+// emptyCodeHash is the code hash of accounts without code.
+var emptyCodeHash = crypto.Keccak256Hash(nil)
+
+// eoaStateDB is a state database presenting a set of accounts as externally
+// owned, i.e. without code, regardless of their actual code.
+//
+// The code of the accounts is hidden from execution too, so calling them
+// doesn't execute any code, just like calling an externally owned account.
+type eoaStateDB struct {
+	vm.StateDB
+
+	eoas map[common.Address]struct{}
+}
+
+func newEOAStateDB(db vm.StateDB, eoas []common.Address) *eoaStateDB {
+	set := make(map[common.Address]struct{}, len(eoas))
+	for _, addr := range eoas {
+		set[addr] = struct{}{}
+	}
+	return &eoaStateDB{StateDB: db, eoas: set}
+}
+
+func (db *eoaStateDB) isEOA(addr common.Address) bool {
+	_, ok := db.eoas[addr]
+	return ok
+}
+
+func (db *eoaStateDB) GetCode(addr common.Address) []byte {
+	if db.isEOA(addr) {
+		return nil
+	}
+	return db.StateDB.GetCode(addr)
+}
+
+func (db *eoaStateDB) GetCodeSize(addr common.Address) int {
+	if db.isEOA(addr) {
+		return 0
+	}
+	return db.StateDB.GetCodeSize(addr)
+}
+
+func (db *eoaStateDB) GetCodeHash(addr common.Address) common.Hash {
+	if db.isEOA(addr) {
+		if !db.StateDB.Exist(addr) {
+			return common.Hash{}
+		}
+		return emptyCodeHash
+	}
+	return db.StateDB.GetCodeHash(addr)
+}
+
+// clearedStateDB is a state database presenting the storage of a set of accounts
+// as cleared, i.e. all slots zero, unless written through it. Writes reverted
+// through it expose the cleared slots again.
+type clearedStateDB struct {
+	vm.StateDB
+
+	cleared   map[common.Address]struct{}
+	writes    []storageSlot       // Slots of the cleared accounts written, in order
+	written   map[storageSlot]int // Number of writes of every slot
+	snapshots map[int]int         // Number of writes at every snapshot
+}
+
+// storageSlot identifies a storage slot of an account.
+type storageSlot struct {
+	addr common.Address
+	key  common.Hash
+}
+
+func newClearedStateDB(db vm.StateDB, cleared []common.Address) *clearedStateDB {
+	set := make(map[common.Address]struct{}, len(cleared))
+	for _, addr := range cleared {
+		set[addr] = struct{}{}
+	}
+	return &clearedStateDB{StateDB: db, cleared: set, written: make(map[storageSlot]int), snapshots: make(map[int]int)}
+}
+
+func (db *clearedStateDB) isCleared(addr common.Address) bool {
+	_, ok := db.cleared[addr]
+	return ok
+}
+
+func (db *clearedStateDB) GetCommittedState(addr common.Address, key common.Hash) common.Hash {
+	if db.isCleared(addr) {
+		return common.Hash{}
+	}
+	return db.StateDB.GetCommittedState(addr, key)
+}
+
+func (db *clearedStateDB) GetState(addr common.Address, key common.Hash) common.Hash {
+	if db.isCleared(addr) && db.written[storageSlot{addr, key}] == 0 {
+		return common.Hash{}
+	}
+	return db.StateDB.GetState(addr, key)
+}
+
+func (db *clearedStateDB) SetState(addr common.Address, key, value common.Hash) {
+	if db.isCleared(addr) {
+		slot := storageSlot{addr, key}
+		db.writes = append(db.writes, slot)
+		db.written[slot]++
+	}
+	db.StateDB.SetState(addr, key, value)
+}
+
+func (db *clearedStateDB) Snapshot() int {
+	id := db.StateDB.Snapshot()
+	db.snapshots[id] = len(db.writes)
+	return id
+}
+
+func (db *clearedStateDB) RevertToSnapshot(id int) {
+	if n, ok := db.snapshots[id]; ok {
+		for _, slot := range db.writes[n:] {
+			db.written[slot]--
+		}
+		db.writes = db.writes[:n]
+	}
+	db.StateDB.RevertToSnapshot(id)
+}
+
+// versionStateDB is a state database versioning the state: every modification
+// made through it increases the version, except for setting the nonce of the
+// sender of the current call, which every call does.
+type versionStateDB struct {
+	vm.StateDB
+
+	sender  common.Address // Sender of the current call
+	version uint64         // Number of modifications made so far
+}
+
+func newVersionStateDB(db vm.StateDB) *versionStateDB {
+	return &versionStateDB{StateDB: db}
+}
+
+func (db *versionStateDB) CreateAccount(addr common.Address) {
+	db.version++
+	db.StateDB.CreateAccount(addr)
+}
+
+func (db *versionStateDB) SubBalance(addr common.Address, amount *big.Int) {
+	if amount.Sign() != 0 {
+		db.version++
+	}
+	db.StateDB.SubBalance(addr, amount)
+}
+
+func (db *versionStateDB) AddBalance(addr common.Address, amount *big.Int) {
+	if amount.Sign() != 0 {
+		db.version++
+	}
+	db.StateDB.AddBalance(addr, amount)
+}
+
+func (db *versionStateDB) SetNonce(addr common.Address, nonce uint64) {
+	if addr != db.sender {
+		db.version++
+	}
+	db.StateDB.SetNonce(addr, nonce)
+}
+
+func (db *versionStateDB) SetCode(addr common.Address, code []byte) {
+	db.version++
+	db.StateDB.SetCode(addr, code)
+}
+
+func (db *versionStateDB) SetState(addr common.Address, key, value common.Hash) {
+	if db.StateDB.GetState(addr, key) != value {
+		db.version++
+	}
+	db.StateDB.SetState(addr, key, value)
+}
+
+func (db *versionStateDB) Suicide(addr common.Address) bool {
+	db.version++
+	return db.StateDB.Suicide(addr)
+}
+
+// readKey identifies a call executed on a version of the state.
+type readKey struct {
+	call    common.Hash // Hash of the message of the call
+	version uint64      // Version of the state the call is executed on
+}
+
+// readHash hashes the parameters of a message determining its execution.
+func readHash(msg core.Message) common.Hash {
+	enc, _ := rlp.EncodeToBytes([]interface{}{msg.From(), msg.To(), msg.Value(), msg.Gas(), msg.GasPrice(), msg.Data()})
+	return crypto.Keccak256Hash(enc)
+}
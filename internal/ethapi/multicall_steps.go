@@ -0,0 +1,170 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// StepSeq is a sequence of the steps of an execution, which yields the steps to
+// the given function until it returns false. Its signature matches iter.Seq, so
+// it can be ranged over with toolchains supporting range-over-func.
+type StepSeq func(yield func(vm.StructLog) bool)
+
+// MulticallSteps prepares the execution of a single call on top of the state of
+// the given block number, returning its steps. The call is executed while the
+// steps are consumed, one operation at a time: every step is yielded before its
+// operation executes, and execution is abandoned once the consumer stops. The
+// steps can only be consumed once, consuming them again yields no steps.
+//
+// The log configuration selects the contents of the steps, its limit is left to
+// the consumer. An execution failing outside of its operations, e.g. as the call
+// is rejected before executing any, ends with a step at depth 0 carrying the
+// error.
+func MulticallSteps(ctx context.Context, b Backend, call CallArgs, blockNr rpc.BlockNumber, overrides map[common.Address]account, logCfg *vm.LogConfig, gasCap *big.Int) (StepSeq, error) {
+	state, header, err := b.StateAndHeaderByNumber(ctx, blockNr)
+	if state == nil || err != nil {
+		return nil, &StateUnavailableError{BlockNr: blockNr, Err: err}
+	}
+	if err := applyOverrides(state, overrides); err != nil {
+		return nil, err
+	}
+	msg := (&MulticallArgs{CallArgs: call}).toMessage(b, gasCap)
+
+	tracer := newStepTracer(logCfg)
+	evm, vmError, err := newMulticallEVM(ctx, b, msg, state, state, header, nil, nil, vm.Config{Debug: true, Tracer: tracer})
+	if err != nil {
+		return nil, err
+	}
+	var consumed int32
+	return func(yield func(vm.StructLog) bool) {
+		if !atomic.CompareAndSwapInt32(&consumed, 0, 1) {
+			return
+		}
+		// Abandon the execution once the steps aren't consumed any further
+		ctx, cancel := context.WithCancel(ctx)
+		go func() {
+			<-ctx.Done()
+			evm.Cancel()
+		}()
+		go func() {
+			defer close(tracer.steps)
+			_, _, _, err := core.ApplyMessage(evm, msg, new(core.GasPool).AddGas(math.MaxUint64))
+			if err == nil {
+				err = vmError()
+			}
+			if err != nil {
+				tracer.hand(vm.StructLog{Err: err})
+			}
+		}()
+		defer func() {
+			cancel()
+			close(tracer.stop)
+			for range tracer.steps {
+			}
+		}()
+		for step := range tracer.steps {
+			if !yield(step) {
+				return
+			}
+			tracer.resume <- struct{}{}
+		}
+	}, nil
+}
+
+// stepTracer hands the steps of an execution over to a consumer, blocking the
+// execution until the consumer resumes it or stops altogether.
+type stepTracer struct {
+	cfg     vm.LogConfig
+	changed map[common.Address]vm.Storage // Storage modified by every contract
+
+	steps  chan vm.StructLog
+	resume chan struct{}
+	stop   chan struct{}
+}
+
+func newStepTracer(cfg *vm.LogConfig) *stepTracer {
+	t := &stepTracer{
+		changed: make(map[common.Address]vm.Storage),
+		steps:   make(chan vm.StructLog),
+		resume:  make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+	if cfg != nil {
+		t.cfg = *cfg
+	}
+	return t
+}
+
+func (t *stepTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+func (t *stepTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	step := vm.StructLog{Pc: pc, Op: op, Gas: gas, GasCost: cost, MemorySize: memory.Len(), Depth: depth, RefundCounter: env.StateDB.GetRefund(), Err: err}
+	if !t.cfg.DisableMemory {
+		step.Memory = common.CopyBytes(memory.Data())
+	}
+	if !t.cfg.DisableStack {
+		step.Stack = make([]*big.Int, len(stack.Data()))
+		for i, item := range stack.Data() {
+			step.Stack[i] = new(big.Int).Set(item)
+		}
+	}
+	if !t.cfg.DisableStorage {
+		addr := contract.Address()
+		if t.changed[addr] == nil {
+			t.changed[addr] = make(vm.Storage)
+		}
+		if op == vm.SSTORE && len(stack.Data()) >= 2 {
+			t.changed[addr][common.BigToHash(stack.Back(0))] = common.BigToHash(stack.Back(1))
+		}
+		step.Storage = t.changed[addr].Copy()
+	}
+	t.hand(step)
+	return nil
+}
+
+// hand hands a step over to the consumer and waits until it is resumed.
+func (t *stepTracer) hand(step vm.StructLog) {
+	select {
+	case t.steps <- step:
+	case <-t.stop:
+		return
+	}
+	select {
+	case <-t.resume:
+	case <-t.stop:
+	}
+}
+
+func (t *stepTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *stepTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
@@ -0,0 +1,2519 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb/memorydb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// multicallBackend is a minimal Backend serving a single in-memory state, only
+// implementing the methods needed for executing multicalls.
+type multicallBackend struct {
+	Backend
+
+	state  *state.StateDB
+	header *types.Header
+	block  *types.Block // Block to serve on top of the state, if any
+	config *params.ChainConfig
+}
+
+func newMulticallBackend(t testing.TB) *multicallBackend {
+	statedb, err := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()))
+	if err != nil {
+		t.Fatalf("failed to create state: %v", err)
+	}
+	return &multicallBackend{
+		state: statedb,
+		header: &types.Header{
+			Number:     big.NewInt(1),
+			Time:       1000,
+			Difficulty: big.NewInt(1),
+			GasLimit:   params.GenesisGasLimit,
+		},
+		config: params.TestChainConfig,
+	}
+}
+
+func (b *multicallBackend) StateAndHeaderByNumber(ctx context.Context, number rpc.BlockNumber) (*state.StateDB, *types.Header, error) {
+	return b.state, b.header, nil
+}
+
+func (b *multicallBackend) BlockByNumber(ctx context.Context, number rpc.BlockNumber) (*types.Block, error) {
+	return b.block, nil
+}
+
+func (b *multicallBackend) GetEVM(ctx context.Context, msg core.Message, state *state.StateDB, header *types.Header) (*vm.EVM, func() error, error) {
+	state.SetBalance(msg.From(), math.MaxBig256)
+	context := core.NewEVMContext(msg, header, nil, &header.Coinbase)
+	return vm.NewEVM(context, state, b.config, vm.Config{}), state.Error, nil
+}
+
+func (b *multicallBackend) ChainConfig() *params.ChainConfig {
+	return b.config
+}
+
+// multicall runs the given calls against the backend's state with the default
+// execution settings.
+func (b *multicallBackend) multicall(t testing.TB, calls []MulticallArgs, config MulticallConfig) *MulticallResult {
+	result, err := DoMulticall(context.Background(), b, calls, rpc.LatestBlockNumber, nil, config, vm.Config{}, 0, nil)
+	if err != nil {
+		t.Fatalf("multicall failed: %v", err)
+	}
+	return result
+}
+
+var (
+	multicallSender   = common.HexToAddress("0x1000000000000000000000000000000000000001")
+	multicallContract = common.HexToAddress("0x2000000000000000000000000000000000000002")
+)
+
+// newCall creates the arguments of a call from the multicall sender to the
+// given address.
+func newCall(to common.Address, data []byte) MulticallArgs {
+	input := hexutil.Bytes(data)
+	return MulticallArgs{CallArgs: CallArgs{From: &multicallSender, To: &to, Data: &input}}
+}
+
+// storeOrRevertCode stores the first calldata word in slot 0, unless it is zero
+// in which case execution is reverted.
+var storeOrRevertCode = []byte{
+	byte(vm.PUSH1), 0x00, byte(vm.CALLDATALOAD), // value
+	byte(vm.DUP1), byte(vm.PUSH1), 0x0b, byte(vm.JUMPI),
+	byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.REVERT),
+	byte(vm.JUMPDEST), byte(vm.PUSH1), 0x00, byte(vm.SSTORE), byte(vm.STOP),
+}
+
+func TestMulticallAtomic(t *testing.T) {
+	calls := []MulticallArgs{
+		newCall(multicallContract, common.LeftPadBytes([]byte{1}, 32)),
+		newCall(multicallContract, common.LeftPadBytes([]byte{0}, 32)),
+		newCall(multicallContract, common.LeftPadBytes([]byte{2}, 32)),
+	}
+	// Without atomicity, the reverting call doesn't affect the others
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+
+	result := b.multicall(t, calls, MulticallConfig{})
+	if len(result.Calls) != 3 || result.RevertedAt != nil {
+		t.Fatalf("unexpected batch outcome: %d calls, reverted at %v", len(result.Calls), result.RevertedAt)
+	}
+	for i, failed := range []bool{false, true, false} {
+		if result.Calls[i].Failed != failed {
+			t.Errorf("call %d: failure mismatch: have %v, want %v", i, result.Calls[i].Failed, failed)
+		}
+	}
+	if have := b.state.GetState(multicallContract, common.Hash{}); have != common.BigToHash(big.NewInt(2)) {
+		t.Errorf("non-atomic slot mismatch: have %x, want 2", have)
+	}
+	// With atomicity, the write of the first call is rolled back
+	b = newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+
+	result = b.multicall(t, calls, MulticallConfig{Atomic: true, Branching: true})
+	if result.RevertedAt == nil || *result.RevertedAt != 1 {
+		t.Fatalf("reverted index mismatch: have %v, want 1", result.RevertedAt)
+	}
+	if len(result.Calls) != 2 {
+		t.Fatalf("result count mismatch: have %d, want 2", len(result.Calls))
+	}
+	if result.Calls[0].Failed || !result.Calls[1].Failed {
+		t.Errorf("call outcomes mismatch: have %v/%v, want false/true", result.Calls[0].Failed, result.Calls[1].Failed)
+	}
+	if have := result.Branch.db.GetState(multicallContract, common.Hash{}); have != (common.Hash{}) {
+		t.Errorf("atomic slot not rolled back: have %x", have)
+	}
+}
+
+// Tests that the calls of an atomic batch are charged as separate transactions,
+// observing the storage committed and the accounts destructed by their
+// predecessors.
+func TestMulticallAtomicGasUsed(t *testing.T) {
+	destructor := common.HexToAddress("0xde57")
+	calls := []MulticallArgs{
+		storeCall(1),
+		storeCall(2),
+		newCall(destructor, nil),
+		newCall(destructor, nil),
+	}
+	var gasUsed [2][]hexutil.Uint64
+	for i, atomic := range []bool{false, true} {
+		// Activate the net gas metering of EIP-1283, which charges writes
+		// depending on the committed value of the slot
+		b := newMulticallBackend(t)
+		config := *params.TestChainConfig
+		config.PetersburgBlock = big.NewInt(1000)
+		b.config = &config
+		b.state.SetCode(multicallContract, storeOrRevertCode)
+		b.state.SetCode(destructor, []byte{byte(vm.PUSH1), 0x00, byte(vm.SELFDESTRUCT)})
+
+		result := b.multicall(t, calls, MulticallConfig{Atomic: atomic})
+		if result.RevertedAt != nil || len(result.Calls) != len(calls) {
+			t.Fatalf("atomic %v: unexpected batch outcome: %d calls, reverted at %v", atomic, len(result.Calls), result.RevertedAt)
+		}
+		for _, res := range result.Calls {
+			if res.Failed {
+				t.Fatalf("atomic %v: call failed: %v", atomic, res.Err)
+			}
+			gasUsed[i] = append(gasUsed[i], res.GasUsed)
+		}
+	}
+	for i := range calls {
+		if gasUsed[1][i] != gasUsed[0][i] {
+			t.Errorf("call %d: gas used mismatch: have %d, want %d", i, gasUsed[1][i], gasUsed[0][i])
+		}
+	}
+	// The destructed contract is gone by the time of the last call
+	if gasUsed[0][3] != hexutil.Uint64(params.TxGas) {
+		t.Errorf("call to destructed contract: gas used mismatch: have %d, want %d", gasUsed[0][3], params.TxGas)
+	}
+}
+
+func TestMulticallExpectedReverts(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+
+	calls := []MulticallArgs{storeCall(1), storeCall(0)}
+	result := b.multicall(t, calls, MulticallConfig{Atomic: true, ExpectedReverts: map[int]bool{1: true}})
+	if result.RevertedAt != nil {
+		t.Fatalf("batch rolled back at %d", *result.RevertedAt)
+	}
+	if len(result.Calls) != 2 || result.Calls[0].Failed || !result.Calls[1].Failed {
+		t.Fatalf("call outcomes mismatch")
+	}
+	if !isError(result.Calls[1].Err, vm.ErrExecutionReverted) {
+		t.Errorf("revert not reported: %v", result.Calls[1].Err)
+	}
+	if have := b.state.GetState(multicallContract, common.Hash{}); have != common.BytesToHash([]byte{1}) {
+		t.Errorf("write of the first call rolled back: have %x", have)
+	}
+	// Failures other than reverts still roll the batch back
+	b = newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+	b.state.SetCode(multicallSender, []byte{byte(vm.STOP)})
+
+	result = b.multicall(t, calls, MulticallConfig{Atomic: true, Enforce3607: true, ExpectedReverts: map[int]bool{0: true}})
+	if result.RevertedAt == nil || *result.RevertedAt != 0 {
+		t.Errorf("reverted index mismatch: have %v, want 0", result.RevertedAt)
+	}
+}
+
+func TestMulticallRefunds(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetState(multicallContract, common.BigToHash(big.NewInt(0)), common.BigToHash(big.NewInt(1)))
+	b.state.SetState(multicallContract, common.BigToHash(big.NewInt(1)), common.BigToHash(big.NewInt(1)))
+
+	// Clear slots 0 and 1, and write zero to the already empty slot 2
+	b.state.SetCode(multicallContract, []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x01, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x02, byte(vm.SSTORE),
+	})
+	result := b.multicall(t, []MulticallArgs{newCall(multicallContract, nil)}, MulticallConfig{TrackRefunds: true})
+
+	res := result.Calls[0]
+	if res.SlotsCleared == nil || *res.SlotsCleared != 2 {
+		t.Fatalf("cleared slots mismatch: have %v, want 2", res.SlotsCleared)
+	}
+	// The uncapped refund (2 * 15000) exceeds half of the gas used, so it must
+	// have been capped to exactly that.
+	gross := uint64(params.TxGas + 6*vm.GasFastestStep + 2*params.SstoreClearGas + params.SstoreResetGas)
+	if res.CappedRefund == nil || uint64(*res.CappedRefund) != gross/2 {
+		t.Fatalf("capped refund mismatch: have %v, want %d", res.CappedRefund, gross/2)
+	}
+	if uint64(res.GasUsed) != gross-gross/2 {
+		t.Errorf("gas used mismatch: have %d, want %d", res.GasUsed, gross-gross/2)
+	}
+	// EIP-3529 would have capped the refund to a fifth of the gas used
+	if res.CappedRefundEIP3529 == nil || uint64(*res.CappedRefundEIP3529) != gross/5 {
+		t.Errorf("EIP-3529 capped refund mismatch: have %v, want %d", res.CappedRefundEIP3529, gross/5)
+	}
+	// Slots cleared by reverted frames aren't counted
+	b = newMulticallBackend(t)
+	callee := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	b.state.SetState(callee, common.Hash{}, common.BigToHash(big.NewInt(1)))
+	b.state.SetCode(callee, []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.REVERT),
+	})
+	b.state.SetState(multicallContract, common.Hash{}, common.BigToHash(big.NewInt(1)))
+	code := []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH20),
+	}
+	code = append(code, callee.Bytes()...)
+	code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP),
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.SSTORE),
+	)
+	b.state.SetCode(multicallContract, code)
+
+	result = b.multicall(t, []MulticallArgs{newCall(multicallContract, nil)}, MulticallConfig{TrackRefunds: true})
+	if res := result.Calls[0]; res.Failed || res.SlotsCleared == nil || *res.SlotsCleared != 1 {
+		t.Errorf("cleared slots mismatch with reverted frame: failed %v, have %v, want 1", res.Failed, res.SlotsCleared)
+	}
+}
+
+func TestMulticallAccumulateLogs(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	emitter := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	b.state.SetCode(multicallContract, []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.LOG0),
+	})
+	b.state.SetCode(emitter, []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.LOG0),
+		byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.LOG0),
+	})
+	calls := []MulticallArgs{newCall(multicallContract, nil), newCall(emitter, nil)}
+	result := b.multicall(t, calls, MulticallConfig{AccumulateLogs: true})
+
+	want := []struct {
+		addr    common.Address
+		txIndex uint
+	}{
+		{multicallContract, 0}, {emitter, 1}, {emitter, 1},
+	}
+	if len(result.AllLogs) != len(want) {
+		t.Fatalf("log count mismatch: have %d, want %d", len(result.AllLogs), len(want))
+	}
+	for i, log := range result.AllLogs {
+		if log.Address != want[i].addr {
+			t.Errorf("log %d: address mismatch: have %x, want %x", i, log.Address, want[i].addr)
+		}
+		if log.TxIndex != want[i].txIndex {
+			t.Errorf("log %d: tx index mismatch: have %d, want %d", i, log.TxIndex, want[i].txIndex)
+		}
+		if log.Index != uint(i) {
+			t.Errorf("log %d: index mismatch: have %d, want %d", i, log.Index, i)
+		}
+		if log.BlockNumber != b.header.Number.Uint64() || log.BlockHash != b.header.Hash() {
+			t.Errorf("log %d: block mismatch: have #%d [%x]", i, log.BlockNumber, log.BlockHash)
+		}
+		if calls := result.Calls[log.TxIndex]; log.TxHash != calls.Logs[0].TxHash {
+			t.Errorf("log %d: tx hash mismatch: have %x, want %x", i, log.TxHash, calls.Logs[0].TxHash)
+		}
+	}
+	// Without accumulation, only the per call logs are returned
+	if result := b.multicall(t, calls, MulticallConfig{}); result.AllLogs != nil {
+		t.Errorf("unrequested logs accumulated: %v", result.AllLogs)
+	}
+}
+
+func TestMulticallCodeResolver(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	var (
+		inspected = common.HexToAddress("0x3000000000000000000000000000000000000003")
+		called    = common.HexToAddress("0x4000000000000000000000000000000000000004")
+		untouched = common.HexToAddress("0x5000000000000000000000000000000000000005")
+	)
+	// Inspect the code size of one contract twice, call another one and return
+	// both the call's output and the inspected code size.
+	code := []byte{byte(vm.PUSH20)}
+	code = append(code, inspected.Bytes()...)
+	code = append(code, byte(vm.EXTCODESIZE), byte(vm.POP), byte(vm.PUSH20))
+	code = append(code, inspected.Bytes()...)
+	code = append(code, byte(vm.EXTCODESIZE))
+	code = append(code, byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH20))
+	code = append(code, called.Bytes()...)
+	code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP))
+	code = append(code, byte(vm.PUSH1), 0x20, byte(vm.MSTORE), byte(vm.PUSH1), 0x40, byte(vm.PUSH1), 0x00, byte(vm.RETURN))
+	b.state.SetCode(multicallContract, code)
+
+	remote := map[common.Address][]byte{
+		inspected: {byte(vm.STOP), byte(vm.STOP), byte(vm.STOP)},
+		called:    {byte(vm.PUSH1), 0x2a, byte(vm.PUSH1), 0x00, byte(vm.MSTORE), byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.RETURN)},
+		untouched: {byte(vm.STOP)},
+	}
+	resolved := make(map[common.Address]int)
+	resolver := func(addr common.Address) ([]byte, error) {
+		resolved[addr]++
+		return remote[addr], nil
+	}
+	calls := []MulticallArgs{newCall(multicallContract, nil), newCall(multicallContract, nil)}
+	result := b.multicall(t, calls, MulticallConfig{CodeResolver: resolver})
+
+	want := append(common.LeftPadBytes([]byte{0x2a}, 32), common.LeftPadBytes([]byte{3}, 32)...)
+	for i, res := range result.Calls {
+		if res.Failed || !bytes.Equal(res.ReturnData, want) {
+			t.Errorf("call %d: result mismatch: have %x (failed %v), want %x", i, res.ReturnData, res.Failed, want)
+		}
+	}
+	for addr, count := range map[common.Address]int{inspected: 1, called: 1, untouched: 0, multicallContract: 0} {
+		if resolved[addr] != count {
+			t.Errorf("resolutions of %x mismatch: have %d, want %d", addr, resolved[addr], count)
+		}
+	}
+}
+
+func TestMulticallReferenceFork(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// Load two storage slots, which got more expensive with Tangerine Whistle
+	b.state.SetCode(multicallContract, []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.SLOAD), byte(vm.POP),
+		byte(vm.PUSH1), 0x01, byte(vm.SLOAD), byte(vm.POP),
+	})
+	calls := []MulticallArgs{newCall(multicallContract, nil)}
+
+	result := b.multicall(t, calls, MulticallConfig{ReferenceFork: "frontier"})
+	res := result.Calls[0]
+	if res.ReferenceGasUsed == nil {
+		t.Fatalf("missing re-priced gas")
+	}
+	if want := uint64(res.GasUsed) - 2*(params.SloadGasEIP150-params.SloadGasFrontier); uint64(*res.ReferenceGasUsed) != want {
+		t.Errorf("re-priced gas mismatch: have %d, want %d", *res.ReferenceGasUsed, want)
+	}
+	// Re-pricing against the active fork must not change anything
+	result = b.multicall(t, calls, MulticallConfig{ReferenceFork: "petersburg"})
+	if res := result.Calls[0]; res.ReferenceGasUsed == nil || *res.ReferenceGasUsed != res.GasUsed {
+		t.Errorf("re-priced gas mismatch: have %v, want %d", res.ReferenceGasUsed, res.GasUsed)
+	}
+	if _, err := DoMulticall(context.Background(), b, calls, rpc.LatestBlockNumber, nil, MulticallConfig{ReferenceFork: "unknown"}, vm.Config{}, 0, nil); err == nil {
+		t.Errorf("unknown reference fork accepted")
+	}
+}
+
+func TestMulticallTxIndex(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// Increment the counter in slot 0 and return its new value
+	b.state.SetCode(multicallContract, []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.SLOAD), byte(vm.PUSH1), 0x01, byte(vm.ADD),
+		byte(vm.DUP1), byte(vm.PUSH1), 0x00, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0x00, byte(vm.MSTORE), byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	})
+	// Assemble a block with two transactions incrementing the counter
+	key, _ := crypto.GenerateKey()
+	signer := types.MakeSigner(b.config, big.NewInt(2))
+
+	var txs types.Transactions
+	for nonce := uint64(0); nonce < 2; nonce++ {
+		tx, err := types.SignTx(types.NewTransaction(nonce, multicallContract, new(big.Int), 100000, new(big.Int), nil), signer, key)
+		if err != nil {
+			t.Fatalf("failed to sign transaction: %v", err)
+		}
+		txs = append(txs, tx)
+	}
+	b.block = types.NewBlock(&types.Header{
+		ParentHash: b.header.Hash(),
+		Number:     big.NewInt(2),
+		Difficulty: big.NewInt(1),
+		GasLimit:   params.GenesisGasLimit,
+	}, txs, nil, nil)
+
+	var (
+		parent = b.state
+		calls  = []MulticallArgs{newCall(multicallContract, nil)}
+	)
+	for index := 0; index <= len(txs); index++ {
+		b.state = parent.Copy()
+
+		txIndex := hexutil.Uint(index)
+		result := b.multicall(t, calls, MulticallConfig{TxIndex: &txIndex})
+		if have, want := new(big.Int).SetBytes(result.Calls[0].ReturnData), big.NewInt(int64(index+1)); have.Cmp(want) != 0 {
+			t.Errorf("index %d: counter mismatch: have %v, want %v", index, have, want)
+		}
+	}
+	txIndex := hexutil.Uint(len(txs) + 1)
+	if _, err := DoMulticall(context.Background(), b, calls, rpc.LatestBlockNumber, nil, MulticallConfig{TxIndex: &txIndex}, vm.Config{}, 0, nil); err == nil {
+		t.Errorf("out of range transaction index accepted")
+	}
+}
+
+func TestMulticallOnCallComplete(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+
+	calls := []MulticallArgs{
+		newCall(multicallContract, common.LeftPadBytes([]byte{1}, 32)),
+		newCall(multicallContract, common.LeftPadBytes([]byte{0}, 32)),
+		newCall(multicallContract, common.LeftPadBytes([]byte{2}, 32)),
+	}
+	var completed []int
+	config := MulticallConfig{
+		OnCallComplete: func(index int, result ExecutionResultArgs) {
+			if result.Failed != (index == 1) {
+				t.Errorf("call %d: failure mismatch: have %v", index, result.Failed)
+			}
+			completed = append(completed, index)
+		},
+	}
+	b.multicall(t, calls, config)
+	if len(completed) != 3 || completed[0] != 0 || completed[1] != 1 || completed[2] != 2 {
+		t.Errorf("completions mismatch: have %v, want [0 1 2]", completed)
+	}
+	// Atomic batches stop notifying once rolled back
+	completed = completed[:0]
+	config.Atomic = true
+	b.multicall(t, calls, config)
+	if len(completed) != 2 {
+		t.Errorf("completions mismatch: have %v, want [0 1]", completed)
+	}
+}
+
+func TestMulticallEffectiveGasPrice(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetBalance(multicallSender, big.NewInt(params.Ether))
+
+	priced := newCall(multicallContract, nil)
+	priced.GasPrice = (*hexutil.Big)(big.NewInt(2 * params.GWei))
+
+	result := b.multicall(t, []MulticallArgs{priced, newCall(multicallContract, nil)}, MulticallConfig{})
+	for i, want := range []int64{2 * params.GWei, 0} {
+		if have := result.Calls[i].EffectiveGasPrice.ToInt(); have.Cmp(big.NewInt(want)) != 0 {
+			t.Errorf("call %d: effective gas price mismatch: have %v, want %v", i, have, want)
+		}
+	}
+	// The sender paid for the gas of the priced call at the effective price
+	cost := new(big.Int).Mul(big.NewInt(int64(result.Calls[0].GasUsed)), big.NewInt(2*params.GWei))
+	if have, want := b.state.GetBalance(multicallSender), new(big.Int).Sub(big.NewInt(params.Ether), cost); have.Cmp(want) != 0 {
+		t.Errorf("sender balance mismatch: have %v, want %v", have, want)
+	}
+}
+
+func TestCallArgsIntrinsicGas(t *testing.T) {
+	to := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	data := hexutil.Bytes{0x00, 0x01, 0x00, 0x02}
+	var (
+		frontier  = params.Rules{}
+		homestead = params.Rules{IsHomestead: true}
+	)
+	tests := []struct {
+		args  CallArgs
+		rules params.Rules
+		want  uint64
+	}{
+		{CallArgs{To: &to}, homestead, params.TxGas},
+		{CallArgs{To: &to, Data: &data}, homestead, params.TxGas + 2*params.TxDataZeroGas + 2*params.TxDataNonZeroGas},
+		{CallArgs{Data: &data}, homestead, params.TxGasContractCreation + 2*params.TxDataZeroGas + 2*params.TxDataNonZeroGas},
+		{CallArgs{Data: &data}, frontier, params.TxGas + 2*params.TxDataZeroGas + 2*params.TxDataNonZeroGas},
+	}
+	for i, tt := range tests {
+		have, err := tt.args.IntrinsicGas(tt.rules)
+		if err != nil {
+			t.Fatalf("test %d: failed to compute intrinsic gas: %v", i, err)
+		}
+		if have != tt.want {
+			t.Errorf("test %d: intrinsic gas mismatch: have %d, want %d", i, have, tt.want)
+		}
+	}
+}
+
+func TestMulticallNonceErrors(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+
+	calls := make([]MulticallArgs, 3)
+	for i, nonce := range []hexutil.Uint64{0, 0, 5} {
+		nonce := nonce
+		calls[i] = storeCall(byte(i + 1))
+		calls[i].Nonce = &nonce
+	}
+	result := b.multicall(t, calls, MulticallConfig{})
+	if res := result.Calls[0]; res.Failed {
+		t.Fatalf("call with matching nonce failed: %v", res.Err)
+	}
+	tests := []struct {
+		err      error
+		code     string
+		provided uint64
+	}{
+		{core.ErrNonceTooLow, "NONCE_TOO_LOW", 0},
+		{core.ErrNonceTooHigh, "NONCE_TOO_HIGH", 5},
+	}
+	for i, tt := range tests {
+		res := result.Calls[i+1]
+		nonceErr, ok := res.Err.(*NonceError)
+		if !res.Failed || !ok {
+			t.Fatalf("call %d: nonce error missing: %v", i+1, res.Err)
+		}
+		if nonceErr.Index != i+1 || nonceErr.Sender != multicallSender || nonceErr.Expected != 1 || nonceErr.Provided != tt.provided {
+			t.Errorf("call %d: nonce error mismatch: %+v", i+1, nonceErr)
+		}
+		if !isError(res.Err, tt.err) || res.ErrorCode != tt.code {
+			t.Errorf("call %d: error mismatch: have %v (%s), want %v", i+1, res.Err, res.ErrorCode, tt.err)
+		}
+	}
+	if have := b.state.GetState(multicallContract, common.Hash{}); have != common.BytesToHash([]byte{1}) {
+		t.Errorf("slot mismatch: have %x, want 1", have)
+	}
+}
+
+func TestMulticallEnforce3607(t *testing.T) {
+	calls := []MulticallArgs{newCall(multicallContract, common.LeftPadBytes([]byte{1}, 32))}
+
+	// Sending from a contract is allowed by default
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+	b.state.SetCode(multicallSender, []byte{byte(vm.STOP)})
+
+	result := b.multicall(t, calls, MulticallConfig{})
+	if res := result.Calls[0]; res.Failed || res.Err != nil {
+		t.Fatalf("call from contract failed: %v", res.Err)
+	}
+	// Sending from a contract is rejected if EIP-3607 is enforced
+	b = newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+	b.state.SetCode(multicallSender, []byte{byte(vm.STOP)})
+
+	result = b.multicall(t, calls, MulticallConfig{Enforce3607: true})
+	if res := result.Calls[0]; !res.Failed || !isError(res.Err, errSenderNoEOA) {
+		t.Fatalf("call from contract not rejected: failed %v, err %v", res.Failed, res.Err)
+	}
+	if have := b.state.GetState(multicallContract, common.Hash{}); have != (common.Hash{}) {
+		t.Errorf("rejected call modified state: slot %x", have)
+	}
+	// Externally owned accounts are unaffected
+	b = newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+
+	result = b.multicall(t, calls, MulticallConfig{Enforce3607: true})
+	if res := result.Calls[0]; res.Failed || res.Err != nil {
+		t.Fatalf("call from eoa failed: %v", res.Err)
+	}
+}
+
+func TestMulticallTraceCallGas(t *testing.T) {
+	callee := common.HexToAddress("0x3000000000000000000000000000000000000003")
+
+	b := newMulticallBackend(t)
+	b.state.SetBalance(multicallContract, big.NewInt(1))
+	b.state.SetCode(callee, []byte{byte(vm.PUSH1), 0x01, byte(vm.POP), byte(vm.STOP)})
+
+	// Transfer value to the callee without forwarding any gas explicitly
+	code := []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH1), 0x01, byte(vm.PUSH20),
+	}
+	code = append(code, callee.Bytes()...)
+	code = append(code, byte(vm.PUSH1), 0x00, byte(vm.CALL), byte(vm.POP), byte(vm.STOP))
+	b.state.SetCode(multicallContract, code)
+
+	result := b.multicall(t, []MulticallArgs{newCall(multicallContract, nil)}, MulticallConfig{TraceCallGas: true})
+	if len(result.Calls[0].CallGas) != 1 {
+		t.Fatalf("call gas records mismatch: have %d, want 1", len(result.Calls[0].CallGas))
+	}
+	call := result.Calls[0].CallGas[0]
+	if call.Op != "CALL" || call.To != callee || call.Depth != 1 {
+		t.Errorf("call mismatch: have %s to %x at depth %d", call.Op, call.To, call.Depth)
+	}
+	if call.Stipend != hexutil.Uint64(params.CallStipend) {
+		t.Errorf("stipend mismatch: have %d, want %d", call.Stipend, params.CallStipend)
+	}
+	if call.GasForwarded != hexutil.Uint64(params.CallStipend) {
+		t.Errorf("forwarded gas mismatch: have %d, want %d", call.GasForwarded, params.CallStipend)
+	}
+	if call.GasReturned == nil || *call.GasReturned != hexutil.Uint64(params.CallStipend-5) {
+		t.Errorf("returned gas mismatch: have %v, want %d", call.GasReturned, params.CallStipend-5)
+	}
+}
+
+func TestMulticallTraceOutOfGas(t *testing.T) {
+	expanding := common.HexToAddress("0x3000000000000000000000000000000000000003")
+
+	b := newMulticallBackend(t)
+	// Expand memory to 1MB, running out of gas at the MSTORE
+	b.state.SetCode(expanding, []byte{
+		byte(vm.PUSH1), 0x01, byte(vm.PUSH3), 0x10, 0x00, 0x00, byte(vm.MSTORE), byte(vm.STOP),
+	})
+	// Call the expanding contract with 10000 gas and recover from its failure
+	code := []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH20),
+	}
+	code = append(code, expanding.Bytes()...)
+	code = append(code, byte(vm.PUSH2), 0x27, 0x10, byte(vm.CALL), byte(vm.POP), byte(vm.STOP))
+	b.state.SetCode(multicallContract, code)
+
+	calls := []MulticallArgs{newCall(expanding, nil), newCall(multicallContract, nil)}
+	gas := hexutil.Uint64(100000)
+	for i := range calls {
+		calls[i].Gas = &gas
+	}
+	result := b.multicall(t, calls, MulticallConfig{TraceOutOfGas: true})
+
+	oog := result.Calls[0].OutOfGasAt
+	if oog == nil {
+		t.Fatalf("out of gas location missing")
+	}
+	if oog.Address != expanding || oog.Depth != 1 || oog.PC != 6 || oog.Op != "MSTORE" {
+		t.Errorf("location mismatch: have %s at pc %d of %x, depth %d", oog.Op, oog.PC, oog.Address, oog.Depth)
+	}
+	// Intrinsic gas and the two pushes are paid for before the MSTORE
+	if want := hexutil.Uint64(100000 - params.TxGas - 6); oog.Gas != want {
+		t.Errorf("remaining gas mismatch: have %d, want %d", oog.Gas, want)
+	}
+	if oog.Cost <= oog.Gas {
+		t.Errorf("cost %d within remaining gas %d", oog.Cost, oog.Gas)
+	}
+	if result.Calls[1].Failed || result.Calls[1].OutOfGasAt != nil {
+		t.Errorf("recovered out of gas reported: %+v", result.Calls[1].OutOfGasAt)
+	}
+}
+
+func TestMulticallMaxValueTransferred(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// Forward one wei of the value received
+	recipient := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	code := []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH1), 0x01, byte(vm.PUSH20),
+	}
+	code = append(code, recipient.Bytes()...)
+	code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP), byte(vm.STOP))
+	b.state.SetCode(multicallContract, code)
+	b.state.SetBalance(multicallSender, big.NewInt(1000))
+
+	calls := []MulticallArgs{newCall(multicallContract, nil), newCall(recipient, nil), newCall(recipient, nil)}
+	for i, value := range []int64{1, 2, 1} {
+		calls[i].Value = (*hexutil.Big)(big.NewInt(value))
+	}
+	limit := (*hexutil.Big)(big.NewInt(3))
+	result := b.multicall(t, calls, MulticallConfig{MaxValueTransferred: limit})
+
+	if len(result.Calls) != 2 {
+		t.Fatalf("batch not aborted: %d calls executed", len(result.Calls))
+	}
+	if res := result.Calls[0]; res.Failed || res.ValueTransferred.ToInt().Int64() != 2 {
+		t.Errorf("under-limit call mismatch: failed %v, transferred %v", res.Failed, res.ValueTransferred)
+	}
+	res := result.Calls[1]
+	limitErr, ok := res.Err.(*ValueLimitError)
+	if !res.Failed || !ok || res.ErrorCode != "VALUE_LIMIT_EXCEEDED" {
+		t.Fatalf("over-limit call not aborted: failed %v, err %v", res.Failed, res.Err)
+	}
+	if limitErr.Index != 1 || limitErr.Transferred.Int64() != 4 || limitErr.Limit.Int64() != 3 {
+		t.Errorf("limit error mismatch: %+v", limitErr)
+	}
+	if result.ValueLimitExceededAt == nil || *result.ValueLimitExceededAt != 1 {
+		t.Errorf("abort index mismatch: have %v, want 1", result.ValueLimitExceededAt)
+	}
+	// Only the under-limit transfers took effect
+	if have := b.state.GetBalance(recipient); have.Int64() != 1 {
+		t.Errorf("recipient balance mismatch: have %v, want 1", have)
+	}
+	// In atomic mode the exceeded limit rolls back the whole batch, but is
+	// reported nonetheless
+	b = newMulticallBackend(t)
+	b.state.SetCode(multicallContract, code)
+	b.state.SetBalance(multicallSender, big.NewInt(1000))
+
+	result = b.multicall(t, calls, MulticallConfig{MaxValueTransferred: limit, Atomic: true, Branching: true})
+	if len(result.Calls) != 2 {
+		t.Fatalf("atomic batch not aborted: %d calls executed", len(result.Calls))
+	}
+	if result.ValueLimitExceededAt == nil || *result.ValueLimitExceededAt != 1 {
+		t.Errorf("atomic abort index mismatch: have %v, want 1", result.ValueLimitExceededAt)
+	}
+	if result.RevertedAt == nil || *result.RevertedAt != 1 {
+		t.Errorf("atomic revert index mismatch: have %v, want 1", result.RevertedAt)
+	}
+	if have := result.Branch.db.GetBalance(recipient); have.Sign() != 0 {
+		t.Errorf("atomic recipient balance mismatch: have %v, want 0", have)
+	}
+	// Transfers which never took effect don't count against the limit, be it
+	// as they were reverted or failed for lack of funds
+	transfer := func(to common.Address, value byte, tail ...vm.OpCode) []byte {
+		code := []byte{
+			byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+			byte(vm.PUSH1), value, byte(vm.PUSH20),
+		}
+		code = append(code, to.Bytes()...)
+		code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP))
+		for _, op := range tail {
+			code = append(code, byte(op))
+		}
+		return code
+	}
+	var (
+		reverting = common.HexToAddress("0x4000000000000000000000000000000000000004")
+		wrapper   = common.HexToAddress("0x5000000000000000000000000000000000000005")
+		broke     = common.HexToAddress("0x6000000000000000000000000000000000000006")
+	)
+	b = newMulticallBackend(t)
+	b.state.SetCode(reverting, transfer(recipient, 5, vm.PUSH1, 0x00, vm.DUP1, vm.REVERT))
+	b.state.SetBalance(reverting, big.NewInt(100))
+	b.state.SetCode(wrapper, transfer(reverting, 0, vm.STOP))
+	b.state.SetCode(broke, transfer(recipient, 0xff, vm.STOP))
+
+	gas := hexutil.Uint64(100000)
+	calls = []MulticallArgs{newCall(reverting, nil), newCall(wrapper, nil), newCall(broke, nil)}
+	for i := range calls {
+		calls[i].Gas = &gas
+	}
+	result = b.multicall(t, calls, MulticallConfig{MaxValueTransferred: (*hexutil.Big)(big.NewInt(1))})
+	if len(result.Calls) != len(calls) || result.ValueLimitExceededAt != nil {
+		t.Fatalf("batch aborted at %v", result.ValueLimitExceededAt)
+	}
+	for i, res := range result.Calls {
+		if res.ValueTransferred.ToInt().Sign() != 0 {
+			t.Errorf("call %d: transferred value mismatch: have %v, want 0", i, res.ValueTransferred)
+		}
+	}
+}
+
+func TestMulticallDiscardFinalState(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// Increment the counter in slot 0 and return its new value
+	b.state.SetCode(multicallContract, []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.SLOAD), byte(vm.PUSH1), 0x01, byte(vm.ADD),
+		byte(vm.DUP1), byte(vm.PUSH1), 0x00, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0x00, byte(vm.MSTORE), byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	})
+	b.state.Finalise(true)
+
+	calls := []MulticallArgs{newCall(multicallContract, nil), newCall(multicallContract, nil)}
+	result := b.multicall(t, calls, MulticallConfig{DiscardFinalState: true})
+
+	for i, res := range result.Calls {
+		if want := common.LeftPadBytes([]byte{byte(i + 1)}, 32); !bytes.Equal(res.ReturnData, want) {
+			t.Errorf("call %d: counter mismatch: have %x, want %x", i, []byte(res.ReturnData), want)
+		}
+	}
+	if have := b.state.GetState(multicallContract, common.Hash{}); have != (common.Hash{}) {
+		t.Errorf("counter leaked past the batch: %x", have)
+	}
+	if nonce := b.state.GetNonce(multicallSender); nonce != 0 {
+		t.Errorf("sender nonce leaked past the batch: %d", nonce)
+	}
+}
+
+func TestMulticallTracePeakOp(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// Forward all gas to an empty account, then store to a fresh slot
+	code := []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH20),
+	}
+	code = append(code, common.HexToAddress("0x3000000000000000000000000000000000000003").Bytes()...)
+	code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP),
+		byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.SSTORE), byte(vm.STOP))
+	b.state.SetCode(multicallContract, code)
+
+	result := b.multicall(t, []MulticallArgs{newCall(multicallContract, nil)}, MulticallConfig{TracePeakOp: true})
+
+	peak := result.Calls[0].PeakOp
+	if peak == nil {
+		t.Fatalf("peak operation missing")
+	}
+	if peak.Address != multicallContract || peak.Depth != 1 || peak.PC != 38 || peak.Op != "SSTORE" {
+		t.Errorf("peak mismatch: have %s at pc %d of %x, depth %d", peak.Op, peak.PC, peak.Address, peak.Depth)
+	}
+	if peak.Gas != hexutil.Uint64(params.SstoreSetGas) {
+		t.Errorf("peak gas mismatch: have %d, want %d", peak.Gas, params.SstoreSetGas)
+	}
+}
+
+func TestMulticallTrackBackwardJumps(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// Loop forever, and count down from 3 with a conditional jump back
+	looping := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	b.state.SetCode(looping, []byte{byte(vm.JUMPDEST), byte(vm.PUSH1), 0x00, byte(vm.JUMP)})
+	b.state.SetCode(multicallContract, []byte{
+		byte(vm.PUSH1), 0x03, byte(vm.JUMPDEST), byte(vm.PUSH1), 0x01, byte(vm.SWAP1), byte(vm.SUB),
+		byte(vm.DUP1), byte(vm.PUSH1), 0x02, byte(vm.JUMPI), byte(vm.STOP),
+	})
+	calls := []MulticallArgs{newCall(looping, nil), newCall(multicallContract, nil)}
+	gas := hexutil.Uint64(100000)
+	for i := range calls {
+		calls[i].Gas = &gas
+	}
+	result := b.multicall(t, calls, MulticallConfig{TrackBackwardJumps: true, BackwardJumpThreshold: 1000})
+
+	looped := result.Calls[0]
+	if !looped.Failed || looped.BackwardJumps == nil || *looped.BackwardJumps < 1000 || !looped.LoopSuspected {
+		t.Errorf("loop not flagged: failed %v, backward jumps %v", looped.Failed, looped.BackwardJumps)
+	}
+	counted := result.Calls[1]
+	if counted.BackwardJumps == nil || *counted.BackwardJumps != 2 || counted.LoopSuspected {
+		t.Errorf("countdown mismatch: backward jumps %v, loop suspected %v", counted.BackwardJumps, counted.LoopSuspected)
+	}
+}
+
+func TestMulticallErrors(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// Revert with the reason "nope", encoded as Error(string)
+	reason := append(append(common.CopyBytes(revertSelector), common.LeftPadBytes([]byte{0x20}, 32)...), common.LeftPadBytes([]byte{4}, 32)...)
+	reason = append(reason, common.RightPadBytes([]byte("nope"), 32)...)
+
+	reverter := []byte{
+		byte(vm.PUSH1), byte(len(reason)), byte(vm.DUP1),
+		byte(vm.PUSH1), 0x0b, byte(vm.PUSH1), 0x00, byte(vm.CODECOPY), // copy the reason appended to the code
+		byte(vm.PUSH1), 0x00, byte(vm.REVERT),
+	}
+	reverter = append(reverter, reason...)
+
+	var (
+		revertAddr = common.HexToAddress("0x3000000000000000000000000000000000000003")
+		loopAddr   = common.HexToAddress("0x4000000000000000000000000000000000000004")
+		invalid    = common.HexToAddress("0x5000000000000000000000000000000000000005")
+	)
+	b.state.SetCode(revertAddr, reverter)
+	b.state.SetCode(loopAddr, []byte{byte(vm.JUMPDEST), byte(vm.PUSH1), 0x00, byte(vm.JUMP)})
+	b.state.SetCode(invalid, []byte{0xfe})
+
+	loop := newCall(loopAddr, nil)
+	gas := hexutil.Uint64(100000)
+	loop.Gas = &gas
+
+	result := b.multicall(t, []MulticallArgs{newCall(revertAddr, nil), loop, newCall(invalid, nil)}, MulticallConfig{})
+
+	revertErr, ok := result.Calls[0].Err.(*CallRevertError)
+	if !ok {
+		t.Fatalf("revert error mismatch: have %v", result.Calls[0].Err)
+	}
+	if revertErr.Index != 0 || revertErr.Reason != "nope" || !bytes.Equal(revertErr.Data, reason) {
+		t.Errorf("revert error mismatch: index %d, reason %q, data %x", revertErr.Index, revertErr.Reason, revertErr.Data)
+	}
+	if !isError(result.Calls[0].Err, vm.ErrExecutionReverted) {
+		t.Errorf("revert error doesn't unwrap to the vm error")
+	}
+	gasErr, ok := result.Calls[1].Err.(*CallOutOfGasError)
+	if !ok {
+		t.Fatalf("out of gas error mismatch: have %v", result.Calls[1].Err)
+	}
+	if gasErr.Index != 1 || gasErr.Gas != uint64(gas) || !isError(gasErr, vm.ErrOutOfGas) {
+		t.Errorf("out of gas error mismatch: index %d, gas %d, err %v", gasErr.Index, gasErr.Gas, gasErr.Err)
+	}
+	if callErr, ok := result.Calls[2].Err.(*CallError); !ok || callErr.Index != 2 {
+		t.Errorf("call error mismatch: have %v", result.Calls[2].Err)
+	}
+	// Batches on missing state fail as a whole
+	b.state = nil
+	_, err := DoMulticall(context.Background(), b, nil, rpc.LatestBlockNumber, nil, MulticallConfig{}, vm.Config{}, 0, nil)
+
+	if stateErr, ok := err.(*StateUnavailableError); !ok || stateErr.BlockNr != rpc.LatestBlockNumber {
+		t.Errorf("state error mismatch: have %v", err)
+	}
+}
+
+func TestMulticallBalanceDeltas(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetBalance(multicallContract, big.NewInt(params.Ether))
+	b.state.SetCode(multicallContract, []byte{
+		byte(vm.ADDRESS), byte(vm.BALANCE), byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	})
+	// A positive delta is observed by the call
+	credit := newCall(multicallContract, nil)
+	credit.BalanceDeltas = map[common.Address]*hexutil.Big{multicallContract: (*hexutil.Big)(big.NewInt(params.Ether))}
+
+	// A negative delta exceeding the balance fails the call
+	debit := newCall(multicallContract, nil)
+	debit.BalanceDeltas = map[common.Address]*hexutil.Big{multicallContract: (*hexutil.Big)(big.NewInt(-3 * params.Ether))}
+
+	result := b.multicall(t, []MulticallArgs{credit, debit}, MulticallConfig{})
+	if have := new(big.Int).SetBytes(result.Calls[0].ReturnData); have.Cmp(big.NewInt(2*params.Ether)) != 0 {
+		t.Errorf("balance mismatch: have %v, want %v", have, 2*params.Ether)
+	}
+	if _, ok := result.Calls[1].Err.(*CallError); !result.Calls[1].Failed || !ok {
+		t.Errorf("underflowing delta not rejected: %v", result.Calls[1].Err)
+	}
+	if have := b.state.GetBalance(multicallContract); have.Cmp(big.NewInt(2*params.Ether)) != 0 {
+		t.Errorf("final balance mismatch: have %v, want %v", have, 2*params.Ether)
+	}
+}
+
+func TestMulticallTrackMemory(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, []byte{
+		byte(vm.PUSH1), 0x01, byte(vm.PUSH2), 0x10, 0x00, byte(vm.MSTORE), // expand to 0x1020 bytes
+		byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.MSTORE), // no expansion
+		byte(vm.STOP),
+	})
+	result := b.multicall(t, []MulticallArgs{newCall(multicallContract, nil)}, MulticallConfig{TrackMemory: true})
+
+	res := result.Calls[0]
+	if res.PeakMemWords == nil || *res.PeakMemWords != 129 {
+		t.Errorf("peak memory mismatch: have %v, want 129", res.PeakMemWords)
+	}
+	if want := hexutil.Uint64(129*params.MemoryGas + 129*129/params.QuadCoeffDiv); res.MemExpansionGas == nil || *res.MemExpansionGas != want {
+		t.Errorf("memory expansion gas mismatch: have %v, want %d", res.MemExpansionGas, want)
+	}
+}
+
+func TestMulticallClearStorage(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// Initialise once, setting slot 0 and returning slot 1
+	b.state.SetCode(multicallContract, []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.SLOAD), byte(vm.ISZERO), byte(vm.PUSH1), 0x0b, byte(vm.JUMPI),
+		byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.REVERT),
+		byte(vm.JUMPDEST), byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0x01, byte(vm.SLOAD), byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	})
+	b.state.SetState(multicallContract, common.Hash{}, common.BigToHash(big.NewInt(1)))
+	b.state.SetState(multicallContract, common.BigToHash(big.NewInt(1)), common.BigToHash(big.NewInt(7)))
+
+	fresh := newCall(multicallContract, nil)
+	fresh.ClearStorage = []common.Address{multicallContract}
+	calls := []MulticallArgs{newCall(multicallContract, nil), fresh, newCall(multicallContract, nil)}
+
+	result := b.multicall(t, calls, MulticallConfig{})
+	for i, want := range []bool{true, false, true} {
+		if res := result.Calls[i]; res.Failed != want {
+			t.Errorf("call %d: failure mismatch: have %v, want %v", i, res.Failed, want)
+		}
+	}
+	// The fresh contract doesn't see the untouched slot, which reappears later
+	if have := new(big.Int).SetBytes(result.Calls[1].ReturnData); have.Sign() != 0 {
+		t.Errorf("cleared slot mismatch: have %v, want 0", have)
+	}
+	if have := b.state.GetState(multicallContract, common.BigToHash(big.NewInt(1))); have != common.BigToHash(big.NewInt(7)) {
+		t.Errorf("untouched slot mismatch: have %x, want 7", have)
+	}
+	// Probing the call for determinism doesn't leak the writes of the probe
+	result = b.multicall(t, []MulticallArgs{fresh}, MulticallConfig{VerifyDeterminism: true})
+	if res := result.Calls[0]; res.Failed || res.Nondeterministic {
+		t.Errorf("probed call mismatch: failed %v, divergences %v", res.Failed, res.Divergences)
+	}
+}
+
+func TestMulticallAsEOA(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallSender, []byte{byte(vm.STOP)})
+
+	// Return whether the caller is an externally owned account
+	b.state.SetCode(multicallContract, []byte{
+		byte(vm.CALLER), byte(vm.EXTCODESIZE), byte(vm.ISZERO), byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	})
+	eoa := newCall(multicallContract, nil)
+	eoa.AsEOA = []common.Address{multicallSender}
+
+	result := b.multicall(t, []MulticallArgs{newCall(multicallContract, nil), eoa, newCall(multicallContract, nil)}, MulticallConfig{})
+	for i, want := range []int64{0, 1, 0} {
+		if have := new(big.Int).SetBytes(result.Calls[i].ReturnData); have.Cmp(big.NewInt(want)) != 0 {
+			t.Errorf("call %d: eoa check mismatch: have %v, want %v", i, have, want)
+		}
+	}
+}
+
+func TestMulticallTrackColdAccess(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	var code []byte
+	for _, slot := range []byte{0, 1, 2, 3, 4, 0, 1} {
+		code = append(code, byte(vm.PUSH1), slot, byte(vm.SLOAD), byte(vm.POP))
+	}
+	code = append(code, byte(vm.PUSH1), 0x05, byte(vm.DUP1), byte(vm.SSTORE)) // warms slot 5 up
+	code = append(code, byte(vm.PUSH1), 0x05, byte(vm.SLOAD), byte(vm.POP))
+	for _, addr := range []byte{0x01, 0x42, 0x42, 0x43} { // precompile, cold, warm, cold
+		code = append(code, byte(vm.PUSH1), addr, byte(vm.BALANCE), byte(vm.POP))
+	}
+	code = append(code, byte(vm.CALLER), byte(vm.BALANCE), byte(vm.POP), byte(vm.STOP))
+	b.state.SetCode(multicallContract, code)
+
+	calls := []MulticallArgs{newCall(multicallContract, nil), newCall(multicallContract, nil)}
+	result := b.multicall(t, calls, MulticallConfig{TrackColdAccess: true})
+	for i, res := range result.Calls {
+		if res.ColdSloads == nil || *res.ColdSloads != 5 {
+			t.Errorf("call %d: cold sloads mismatch: have %v, want 5", i, res.ColdSloads)
+		}
+		if res.ColdAccountAccesses == nil || *res.ColdAccountAccesses != 2 {
+			t.Errorf("call %d: cold account accesses mismatch: have %v, want 2", i, res.ColdAccountAccesses)
+		}
+	}
+}
+
+func TestMulticallAccessListGasDelta(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// The recipient loads a single slot of its own, whereas the other contract
+	// loads many slots of an account it has to access first
+	other := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	b.state.SetCode(multicallContract, []byte{byte(vm.PUSH1), 0x00, byte(vm.SLOAD), byte(vm.POP), byte(vm.STOP)})
+
+	var code []byte
+	for slot := byte(0); slot < 5; slot++ {
+		code = append(code, byte(vm.PUSH1), slot, byte(vm.SLOAD), byte(vm.POP))
+	}
+	b.state.SetCode(other, code)
+	caller := common.HexToAddress("0x4000000000000000000000000000000000000004")
+	code = []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH20),
+	}
+	code = append(code, other.Bytes()...)
+	code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP), byte(vm.STOP))
+	b.state.SetCode(caller, code)
+
+	calls := []MulticallArgs{newCall(multicallContract, nil), newCall(caller, nil)}
+	result := b.multicall(t, calls, MulticallConfig{TrackColdAccess: true})
+
+	// Listing the recipient costs more than loading its slot saves
+	if have, want := result.Calls[0].AccessListGasDelta(), int64(2400+1900-2000); have != want {
+		t.Errorf("recipient delta mismatch: have %d, want %d", have, want)
+	}
+	list := result.Calls[1].AccessList
+	if len(list) != 1 || list[0].Address != other || len(list[0].StorageKeys) != 5 {
+		t.Fatalf("access list mismatch: %+v", list)
+	}
+	if have, want := result.Calls[1].AccessListGasDelta(), int64(2400+5*1900-2500-5*2000); have != want || have >= 0 {
+		t.Errorf("access list delta mismatch: have %d, want %d", have, want)
+	}
+	// Without tracking cold accesses, there's no delta
+	result = b.multicall(t, calls, MulticallConfig{})
+	if have := result.Calls[1].AccessListGasDelta(); have != 0 {
+		t.Errorf("untracked delta mismatch: have %d, want 0", have)
+	}
+}
+
+func TestMulticallWarmCoinbase(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.header.Coinbase = common.HexToAddress("0x3000000000000000000000000000000000000003")
+	b.state.SetCode(multicallContract, []byte{byte(vm.COINBASE), byte(vm.BALANCE), byte(vm.POP), byte(vm.STOP)})
+
+	warm, cold := true, false
+	tests := []struct {
+		warmCoinbase *bool
+		cold         uint64
+	}{
+		{nil, 1},
+		{&cold, 1},
+		{&warm, 0},
+	}
+	for i, tt := range tests {
+		result := b.multicall(t, []MulticallArgs{newCall(multicallContract, nil)}, MulticallConfig{TrackColdAccess: true, WarmCoinbase: tt.warmCoinbase})
+		if have := result.Calls[0].ColdAccountAccesses; have == nil || uint64(*have) != tt.cold {
+			t.Errorf("test %d: cold account accesses mismatch: have %v, want %d", i, have, tt.cold)
+		}
+	}
+}
+
+func TestMulticallWarmAccesses(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.SLOAD), byte(vm.POP), byte(vm.PUSH1), 0x01, byte(vm.SLOAD), byte(vm.POP),
+		byte(vm.PUSH1), 0x30, byte(vm.BALANCE), byte(vm.POP), byte(vm.STOP),
+	})
+
+	cold := newCall(multicallContract, nil)
+	warm := newCall(multicallContract, nil)
+	warm.WarmAddresses = []common.Address{common.BigToAddress(big.NewInt(0x30))}
+	warm.WarmSlots = map[common.Address][]common.Hash{multicallContract: {common.Hash{}}}
+
+	result := b.multicall(t, []MulticallArgs{cold, warm}, MulticallConfig{TrackColdAccess: true})
+	for i, want := range []struct{ sloads, accounts uint64 }{{2, 1}, {1, 0}} {
+		res := result.Calls[i]
+		if res.ColdSloads == nil || uint64(*res.ColdSloads) != want.sloads {
+			t.Errorf("call %d: cold sloads mismatch: have %v, want %d", i, res.ColdSloads, want.sloads)
+		}
+		if res.ColdAccountAccesses == nil || uint64(*res.ColdAccountAccesses) != want.accounts {
+			t.Errorf("call %d: cold account accesses mismatch: have %v, want %d", i, res.ColdAccountAccesses, want.accounts)
+		}
+	}
+	// The pre-warmed slot is loaded at the warm price, so it isn't listed
+	list := result.Calls[1].AccessList
+	if len(list) != 1 || list[0].Address != multicallContract || len(list[0].StorageKeys) != 1 || list[0].StorageKeys[0] != common.BigToHash(big.NewInt(1)) {
+		t.Errorf("access list mismatch: %+v", list)
+	}
+	// Pre-warming doesn't add to the intrinsic gas
+	if result.Calls[0].GasUsed != result.Calls[1].GasUsed {
+		t.Errorf("gas used mismatch: have %d, want %d", result.Calls[1].GasUsed, result.Calls[0].GasUsed)
+	}
+}
+
+func TestMulticallFixedTimestamp(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, []byte{
+		byte(vm.TIMESTAMP), byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	})
+	calls := []MulticallArgs{newCall(multicallContract, nil), newCall(multicallContract, nil), newCall(multicallContract, nil)}
+
+	fixed := hexutil.Uint64(1234567890)
+	result := b.multicall(t, calls, MulticallConfig{FixedTimestamp: &fixed, Branching: true})
+	results := append(result.Calls, branchMulticall(t, result.Branch, calls[0])...)
+	for i, res := range results {
+		if have := new(big.Int).SetBytes(res.ReturnData).Uint64(); have != uint64(fixed) {
+			t.Errorf("call %d: timestamp mismatch: have %d, want %d", i, have, fixed)
+		}
+	}
+	if b.header.Time != 1000 {
+		t.Errorf("fixed timestamp leaked into the backend header")
+	}
+}
+
+func TestMulticallChainIDOverride(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, []byte{
+		byte(vm.CHAINID), byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	})
+	// CHAINID is only available through EIP-1344 prior to Istanbul
+	vmCfg := vm.Config{ExtraEips: []int{1344}}
+
+	for _, want := range []*big.Int{nil, big.NewInt(10)} {
+		config := MulticallConfig{ChainIDOverride: (*hexutil.Big)(want)}
+		if want == nil {
+			want = b.config.ChainID
+		}
+		result, err := DoMulticall(context.Background(), b, []MulticallArgs{newCall(multicallContract, nil)}, rpc.LatestBlockNumber, nil, config, vmCfg, 0, nil)
+		if err != nil {
+			t.Fatalf("multicall failed: %v", err)
+		}
+		if have := new(big.Int).SetBytes(result.Calls[0].ReturnData); have.Cmp(want) != 0 {
+			t.Errorf("chain id mismatch: have %v, want %v", have, want)
+		}
+	}
+	if b.config.ChainID.Cmp(big.NewInt(10)) == 0 {
+		t.Errorf("chain id override leaked into the backend config")
+	}
+}
+
+func TestMulticallReportOpcodes(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// Shifts were introduced by Constantinople, CHAINID is enabled by EIP-1344
+	byzantium := *params.TestChainConfig
+	byzantium.ConstantinopleBlock, byzantium.PetersburgBlock = nil, nil
+
+	tests := []struct {
+		config *params.ChainConfig
+		eips   []int
+		want   map[string]bool
+	}{
+		{params.TestChainConfig, nil, map[string]bool{"SHL": true, "CHAINID": false}},
+		{&byzantium, nil, map[string]bool{"SHL": false, "CHAINID": false}},
+		{&byzantium, []int{1344}, map[string]bool{"SHL": false, "CHAINID": true}},
+	}
+	for i, tt := range tests {
+		b.config = tt.config
+		result, err := DoMulticall(context.Background(), b, nil, rpc.LatestBlockNumber, nil, MulticallConfig{ReportOpcodes: true}, vm.Config{ExtraEips: tt.eips}, 0, nil)
+		if err != nil {
+			t.Fatalf("test %d: multicall failed: %v", i, err)
+		}
+		enabled := make(map[string]bool)
+		for _, op := range result.EnabledOpcodes {
+			enabled[op] = true
+		}
+		if !enabled["STOP"] || !enabled["SSTORE"] {
+			t.Errorf("test %d: basic opcodes missing: %v", i, result.EnabledOpcodes)
+		}
+		for op, want := range tt.want {
+			if enabled[op] != want {
+				t.Errorf("test %d: %s enabled mismatch: have %v, want %v", i, op, enabled[op], want)
+			}
+		}
+	}
+}
+
+func TestMulticallDifficulty(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, []byte{
+		byte(vm.DIFFICULTY), byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	})
+	for _, want := range []*big.Int{nil, big.NewInt(131072)} {
+		result := b.multicall(t, []MulticallArgs{newCall(multicallContract, nil)}, MulticallConfig{Difficulty: (*hexutil.Big)(want)})
+		if want == nil {
+			want = b.header.Difficulty
+		}
+		if have := new(big.Int).SetBytes(result.Calls[0].ReturnData); have.Cmp(want) != 0 {
+			t.Errorf("difficulty mismatch: have %v, want %v", have, want)
+		}
+	}
+	if b.header.Difficulty.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("difficulty override leaked into the backend header")
+	}
+	config := MulticallConfig{Difficulty: (*hexutil.Big)(big.NewInt(-1))}
+	if _, err := DoMulticall(context.Background(), b, nil, rpc.LatestBlockNumber, nil, config, vm.Config{}, 0, nil); err == nil {
+		t.Errorf("negative difficulty accepted")
+	}
+}
+
+func TestMulticallTrackBalances(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetBalance(multicallSender, big.NewInt(params.Ether))
+
+	recipient := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	transfer := func(amount int64) MulticallArgs {
+		call := newCall(recipient, nil)
+		call.Value = (*hexutil.Big)(big.NewInt(amount))
+		return call
+	}
+	calls := []MulticallArgs{transfer(params.GWei), transfer(2 * params.GWei)}
+	config := MulticallConfig{TrackBalances: []common.Address{multicallSender, recipient}}
+
+	result := b.multicall(t, calls, config)
+	for i, want := range []int64{params.Ether - params.GWei, params.Ether - 3*params.GWei} {
+		if have := result.Calls[i].Balances[multicallSender].ToInt(); have.Cmp(big.NewInt(want)) != 0 {
+			t.Errorf("call %d: sender balance mismatch: have %v, want %v", i, have, want)
+		}
+	}
+	if have := result.FinalBalances[multicallSender].ToInt(); have.Cmp(big.NewInt(params.Ether-3*params.GWei)) != 0 {
+		t.Errorf("final sender balance mismatch: have %v, want %v", have, params.Ether-3*params.GWei)
+	}
+	if have := result.FinalBalances[recipient].ToInt(); have.Cmp(big.NewInt(3*params.GWei)) != 0 {
+		t.Errorf("final recipient balance mismatch: have %v, want %v", have, 3*params.GWei)
+	}
+}
+
+func TestMulticallPrecompiles(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	var (
+		identity  = common.BytesToAddress([]byte{4})
+		ecrecover = common.BytesToAddress([]byte{1})
+		pairing   = common.BytesToAddress([]byte{8})
+
+		input = bytes.Repeat([]byte{0xff}, 33)
+	)
+	pairingCall := newCall(pairing, make([]byte, 10)) // not a multiple of a pair's size
+	gas := hexutil.Uint64(100000)
+	pairingCall.Gas = &gas
+
+	calls := []MulticallArgs{newCall(identity, input), newCall(ecrecover, make([]byte, 128)), pairingCall, newCall(multicallContract, nil)}
+	result := b.multicall(t, calls, MulticallConfig{})
+
+	// The identity precompile echoes its input for 15 gas plus 3 per word
+	res := result.Calls[0]
+	if res.Failed || !bytes.Equal(res.ReturnData, input) {
+		t.Errorf("identity mismatch: failed %v, output %x", res.Failed, res.ReturnData)
+	}
+	if res.Precompile == nil || res.Precompile.Address != identity || res.Precompile.RequiredGas != 21 {
+		t.Errorf("identity annotation mismatch: have %+v", res.Precompile)
+	}
+	if want := hexutil.Uint64(params.TxGas + 33*params.TxDataNonZeroGas + 21); res.GasUsed != want {
+		t.Errorf("identity gas mismatch: have %d, want %d", res.GasUsed, want)
+	}
+	// Recovering an invalid signature succeeds without output
+	res = result.Calls[1]
+	if res.Failed || len(res.ReturnData) != 0 || res.Precompile == nil || res.Precompile.RequiredGas != hexutil.Uint64(params.EcrecoverGas) {
+		t.Errorf("ecrecover mismatch: failed %v, output %x, annotation %+v", res.Failed, res.ReturnData, res.Precompile)
+	}
+	// Malformed pairing input fails, consuming all gas
+	res = result.Calls[2]
+	if !res.Failed || res.GasUsed != gas || res.Precompile == nil || res.Precompile.Address != pairing {
+		t.Errorf("pairing mismatch: failed %v, gas used %d, annotation %+v", res.Failed, res.GasUsed, res.Precompile)
+	}
+	// Regular accounts are not annotated
+	if result.Calls[3].Precompile != nil {
+		t.Errorf("non-precompile annotated: %+v", result.Calls[3].Precompile)
+	}
+}
+
+func TestMulticallRewriteInput(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.CALLDATALOAD), byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	})
+	calls := []MulticallArgs{
+		newCall(multicallContract, common.LeftPadBytes([]byte{1}, 32)),
+		newCall(multicallContract, common.LeftPadBytes([]byte{2}, 32)),
+	}
+	config := MulticallConfig{
+		RewriteInput: func(index int, data []byte) []byte {
+			if index == 0 {
+				return data
+			}
+			data[31] += 40
+			return data
+		},
+	}
+	result := b.multicall(t, calls, config)
+	for i, want := range []byte{1, 42} {
+		if have := result.Calls[i].ReturnData; !bytes.Equal(have, common.LeftPadBytes([]byte{want}, 32)) {
+			t.Errorf("call %d: output mismatch: have %x, want %d", i, have, want)
+		}
+		if have := result.Calls[i].Input; !bytes.Equal(have, common.LeftPadBytes([]byte{want}, 32)) {
+			t.Errorf("call %d: reported input mismatch: have %x, want %d", i, have, want)
+		}
+	}
+	// The arguments of the calls are left untouched
+	if have := (*calls[1].Data)[31]; have != 2 {
+		t.Errorf("call arguments modified: have %d, want 2", have)
+	}
+}
+
+func TestMulticallTraceFrameGas(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// The child writes three fresh slots, for 3 * (3 + 3 + 20000) gas
+	child := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	var childCode []byte
+	for slot := byte(0); slot < 3; slot++ {
+		childCode = append(childCode, byte(vm.PUSH1), 0x01, byte(vm.PUSH1), slot, byte(vm.SSTORE))
+	}
+	b.state.SetCode(child, append(childCode, byte(vm.STOP)))
+
+	code := []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH20),
+	}
+	code = append(code, child.Bytes()...)
+	code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP), byte(vm.STOP))
+	b.state.SetCode(multicallContract, code)
+
+	result := b.multicall(t, []MulticallArgs{newCall(multicallContract, nil)}, MulticallConfig{TraceFrameGas: true})
+	res := result.Calls[0]
+	if len(res.FrameGas) != 2 {
+		t.Fatalf("frame count mismatch: have %d, want 2", len(res.FrameGas))
+	}
+	top, callee := res.FrameGas[0], res.FrameGas[1]
+	if top.To != multicallContract || top.Depth != 1 || callee.To != child || callee.Depth != 2 || callee.Op != "CALL" {
+		t.Errorf("frames mismatch: have %+v and %+v", top, callee)
+	}
+	if callee.TotalGas != 60018 || callee.SelfGas != 60018 {
+		t.Errorf("callee gas mismatch: total %d, self %d, want 60018", callee.TotalGas, callee.SelfGas)
+	}
+	if top.TotalGas != res.GasUsed-hexutil.Uint64(params.TxGas) {
+		t.Errorf("top total gas mismatch: have %d, want %d", top.TotalGas, res.GasUsed-hexutil.Uint64(params.TxGas))
+	}
+	if top.SelfGas != top.TotalGas-callee.TotalGas || top.SelfGas > 1000 {
+		t.Errorf("top self gas mismatch: have %d, total %d", top.SelfGas, top.TotalGas)
+	}
+}
+
+func TestMulticallCreateAddress(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// Deploy a contract returning 42
+	runtime := []byte{
+		byte(vm.PUSH1), 0x2a, byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	}
+	initcode := append([]byte{
+		byte(vm.PUSH1), byte(len(runtime)), byte(vm.PUSH1), 0x0c, byte(vm.PUSH1), 0x00, byte(vm.CODECOPY),
+		byte(vm.PUSH1), byte(len(runtime)), byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	}, runtime...)
+
+	target := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	input := hexutil.Bytes(initcode)
+	deploy := MulticallArgs{CallArgs: CallArgs{From: &multicallSender, Data: &input}, CreateAddress: &target}
+
+	// Deploying to an occupied address fails
+	b.state.SetCode(multicallContract, []byte{byte(vm.STOP)})
+	occupied := deploy
+	occupied.CreateAddress = &multicallContract
+
+	result := b.multicall(t, []MulticallArgs{deploy, newCall(target, nil), occupied}, MulticallConfig{})
+	if res := result.Calls[0]; res.Failed {
+		t.Fatalf("deployment failed: %v", res.Err)
+	}
+	if have := b.state.GetCode(target); !bytes.Equal(have, runtime) {
+		t.Errorf("deployed code mismatch: have %x, want %x", have, runtime)
+	}
+	if have := result.Calls[1].ReturnData; !bytes.Equal(have, common.LeftPadBytes([]byte{42}, 32)) {
+		t.Errorf("call to deployed contract mismatch: have %x", have)
+	}
+	if res := result.Calls[2]; !res.Failed || !isError(res.Err, vm.ErrContractAddressCollision) {
+		t.Errorf("deployment to occupied address not rejected: %v", res.Err)
+	}
+}
+
+func TestMulticallExceedsBlockGasLimit(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.header.GasLimit = 40000
+
+	// Every transfer fits into a block, but all of them don't
+	recipient := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	calls := []MulticallArgs{newCall(recipient, nil), newCall(recipient, nil), newCall(recipient, nil)}
+
+	result := b.multicall(t, calls, MulticallConfig{})
+	for i, res := range result.Calls {
+		if res.ExceedsBlockGasLimit {
+			t.Errorf("call %d: transfer exceeds block gas limit", i)
+		}
+	}
+	if result.GasUsed != hexutil.Uint64(3*params.TxGas) || !result.ExceedsBlockGasLimit {
+		t.Errorf("batch gas mismatch: used %d, exceeds %v", result.GasUsed, result.ExceedsBlockGasLimit)
+	}
+	// A single heavy call exceeds the limit on its own
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+
+	result = b.multicall(t, []MulticallArgs{newCall(multicallContract, common.LeftPadBytes([]byte{1}, 32))}, MulticallConfig{})
+	if res := result.Calls[0]; res.Failed || !res.ExceedsBlockGasLimit {
+		t.Errorf("heavy call not flagged: failed %v, gas used %d", res.Failed, res.GasUsed)
+	}
+}
+
+func TestMulticallMaxGasPerCall(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+
+	// A plain transfer stays below the ceiling, whereas storing exceeds it
+	recipient := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	calls := []MulticallArgs{newCall(recipient, nil), newCall(multicallContract, common.LeftPadBytes([]byte{1}, 32))}
+
+	result := b.multicall(t, calls, MulticallConfig{MaxGasPerCall: 30000})
+	for i, want := range []bool{false, true} {
+		if res := result.Calls[i]; res.Failed || res.GasPolicyViolated != want {
+			t.Errorf("call %d: policy violation mismatch: have %v, want %v (failed %v, gas used %d)", i, res.GasPolicyViolated, want, res.Failed, res.GasUsed)
+		}
+	}
+}
+
+func TestMulticallEncodeTransactions(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+	b.state.SetBalance(multicallSender, big.NewInt(1000000))
+
+	store := newCall(multicallContract, common.LeftPadBytes([]byte{1}, 32))
+	gas := hexutil.Uint64(100000)
+	store.Gas, store.Value, store.GasPrice = &gas, (*hexutil.Big)(big.NewInt(0)), (*hexutil.Big)(big.NewInt(2))
+	deploy := newCall(common.Address{}, []byte{byte(vm.STOP)})
+	deploy.To = nil
+	calls := []MulticallArgs{store, newCall(multicallContract, nil), deploy}
+
+	result := b.multicall(t, calls, MulticallConfig{EncodeTransactions: true})
+	if res := result.Calls[1]; !res.Failed || res.Transaction != nil {
+		t.Errorf("failed call encoded: %x", []byte(res.Transaction))
+	}
+	tests := []struct {
+		index    int
+		nonce    uint64
+		to       *common.Address
+		gas      uint64
+		gasPrice int64
+		data     []byte
+	}{
+		{0, 0, &multicallContract, 100000, 2, *store.Data},
+		{2, 2, nil, uint64(result.Calls[2].GasUsed), 0, *deploy.Data},
+	}
+	for _, tt := range tests {
+		var tx types.Transaction
+		if err := rlp.DecodeBytes(result.Calls[tt.index].Transaction, &tx); err != nil {
+			t.Fatalf("call %d: failed to decode transaction: %v", tt.index, err)
+		}
+		if tx.Nonce() != tt.nonce || !reflect.DeepEqual(tx.To(), tt.to) || tx.Gas() != tt.gas || tx.GasPrice().Int64() != tt.gasPrice || tx.Value().Sign() != 0 || !bytes.Equal(tx.Data(), tt.data) {
+			t.Errorf("call %d: transaction mismatch: nonce %d, to %v, gas %d, gas price %v, value %v, data %x", tt.index, tx.Nonce(), tx.To(), tx.Gas(), tx.GasPrice(), tx.Value(), tx.Data())
+		}
+		if v, r, s := tx.RawSignatureValues(); v.Sign() != 0 || r.Sign() != 0 || s.Sign() != 0 {
+			t.Errorf("call %d: transaction signed", tt.index)
+		}
+	}
+}
+
+func TestMulticallTraceWriter(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+
+	var (
+		trace  bytes.Buffer
+		calls  = []MulticallArgs{newCall(multicallContract, common.LeftPadBytes([]byte{1}, 32)), newCall(multicallContract, nil)}
+		config = MulticallConfig{TraceWriter: &trace, TraceConfig: &vm.LogConfig{DisableMemory: true}}
+	)
+	result := b.multicall(t, calls, config)
+	for i, res := range result.Calls {
+		if !res.TraceStreamed {
+			t.Errorf("call %d: trace not flagged as streamed", i)
+		}
+	}
+	// Every line is a JSON object, either a struct log or the summary of a call
+	var steps, summaries int
+	for i, line := range bytes.Split(bytes.TrimSpace(trace.Bytes()), []byte("\n")) {
+		var entry map[string]interface{}
+		if err := json.Unmarshal(line, &entry); err != nil {
+			t.Fatalf("line %d: invalid json %q: %v", i, line, err)
+		}
+		switch {
+		case entry["op"] != nil:
+			steps++
+		case entry["gasUsed"] != nil:
+			summaries++
+		default:
+			t.Errorf("line %d: unexpected entry %q", i, line)
+		}
+	}
+	if summaries != len(calls) {
+		t.Errorf("summary count mismatch: have %d, want %d", summaries, len(calls))
+	}
+	// The storing call runs 9 operations, the reverting one 8
+	if steps != 17 {
+		t.Errorf("step count mismatch: have %d, want 17", steps)
+	}
+}
+
+func TestMulticallGasOverrides(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	var code []byte
+	for slot := byte(0); slot < 3; slot++ {
+		code = append(code, byte(vm.PUSH1), slot, byte(vm.SLOAD), byte(vm.POP))
+	}
+	b.state.SetCode(multicallContract, append(code, byte(vm.STOP)))
+
+	calls := []MulticallArgs{newCall(multicallContract, nil)}
+	original := b.multicall(t, calls, MulticallConfig{}).Calls[0]
+	if original.GasOverrideDelta != nil {
+		t.Errorf("gas override delta reported without overrides: %v", original.GasOverrideDelta)
+	}
+	repriced := b.multicall(t, calls, MulticallConfig{GasOverrides: map[string]hexutil.Uint64{"SLOAD": 5000}}).Calls[0]
+
+	delta := int64(3 * (5000 - params.SloadGasEIP150))
+	if have := int64(repriced.GasUsed) - int64(original.GasUsed); have != delta {
+		t.Errorf("gas used difference mismatch: have %d, want %d", have, delta)
+	}
+	if repriced.GasOverrideDelta == nil || repriced.GasOverrideDelta.ToInt().Int64() != delta {
+		t.Errorf("gas override delta mismatch: have %v, want %d", repriced.GasOverrideDelta, delta)
+	}
+	// Unknown opcodes are rejected
+	_, err := DoMulticall(context.Background(), b, calls, rpc.LatestBlockNumber, nil, MulticallConfig{GasOverrides: map[string]hexutil.Uint64{"SLOADX": 1}}, vm.Config{}, 0, nil)
+	if err == nil {
+		t.Errorf("unknown opcode accepted")
+	}
+}
+
+func TestMulticallGroupLogs(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	emit := func(v byte) []byte {
+		return []byte{byte(vm.PUSH1), v, byte(vm.PUSH1), 0x00, byte(vm.MSTORE), byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.LOG0)}
+	}
+	child := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	b.state.SetCode(child, append(append(emit(2), emit(3)...), byte(vm.STOP)))
+
+	// Emit a log, have the child emit two, then emit another one
+	code := append(emit(1),
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH20),
+	)
+	code = append(code, child.Bytes()...)
+	code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP))
+	code = append(append(code, emit(4)...), byte(vm.STOP))
+	b.state.SetCode(multicallContract, code)
+
+	res := b.multicall(t, []MulticallArgs{newCall(multicallContract, nil)}, MulticallConfig{GroupLogs: true}).Calls[0]
+	if len(res.Logs) != 4 || len(res.LogsByAddress) != 2 {
+		t.Fatalf("log count mismatch: have %d logs in %d groups, want 4 in 2", len(res.Logs), len(res.LogsByAddress))
+	}
+	for addr, want := range map[common.Address][]byte{multicallContract: {1, 4}, child: {2, 3}} {
+		logs := res.LogsByAddress[addr]
+		if len(logs) != len(want) {
+			t.Fatalf("%x: log count mismatch: have %d, want %d", addr, len(logs), len(want))
+		}
+		for i, v := range want {
+			if logs[i].Address != addr || logs[i].Data[31] != v {
+				t.Errorf("%x: log %d mismatch: have %x from %x, want %d", addr, i, logs[i].Data, logs[i].Address, v)
+			}
+		}
+	}
+}
+
+func TestMulticallMocks(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// The contract returns whatever the mocked one returns, which in turn
+	// writes a storage slot and returns 7 when actually executed
+	mocked := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	code := []byte{
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH20),
+	}
+	code = append(code, mocked.Bytes()...)
+	code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP), byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.RETURN))
+	b.state.SetCode(multicallContract, code)
+	b.state.SetCode(mocked, []byte{
+		byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0x07, byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	})
+	canned := common.LeftPadBytes([]byte{42}, 32)
+
+	mock := newCall(multicallContract, nil)
+	mock.Mocks = map[common.Address]hexutil.Bytes{mocked: canned}
+	charged := mock
+	charged.MockGas = 1000
+
+	result := b.multicall(t, []MulticallArgs{mock, charged}, MulticallConfig{})
+	for i, res := range result.Calls {
+		if res.Failed {
+			t.Fatalf("call %d failed: %v", i, res.Err)
+		}
+		if !bytes.Equal(res.ReturnData, canned) {
+			t.Errorf("call %d: return data mismatch: have %x, want %x", i, res.ReturnData, canned)
+		}
+	}
+	if have := b.state.GetState(mocked, common.Hash{}); have != (common.Hash{}) {
+		t.Errorf("mocked code executed, slot set to %x", have)
+	}
+	if diff := result.Calls[1].GasUsed - result.Calls[0].GasUsed; diff != 1000 {
+		t.Errorf("mock gas mismatch: have %d, want %d", diff, 1000)
+	}
+	// Tracers observe top level calls to mocks like any other call
+	for _, tt := range []struct {
+		gas     hexutil.Uint64
+		mockGas hexutil.Uint64
+		err     error
+	}{
+		{100000, 1000, nil},
+		{100000, 200000, vm.ErrOutOfGas},
+	} {
+		call := newCall(mocked, nil)
+		call.Gas = &tt.gas
+		call.Mocks = map[common.Address]hexutil.Bytes{mocked: canned}
+		call.MockGas = tt.mockGas
+
+		logger := vm.NewStructLogger(nil)
+		result, err := DoMulticall(context.Background(), b, []MulticallArgs{call}, rpc.LatestBlockNumber, nil, MulticallConfig{}, vm.Config{Debug: true, Tracer: logger}, 0, nil)
+		if err != nil {
+			t.Fatalf("multicall failed: %v", err)
+		}
+		if logger.Error() != tt.err || (tt.err == nil && !bytes.Equal(logger.Output(), canned)) {
+			t.Errorf("mock gas %d: traced frame mismatch: err %v, output %x", tt.mockGas, logger.Error(), logger.Output())
+		}
+		if res := result.Calls[0]; res.Failed != (tt.err != nil) || (tt.err != nil && !isError(res.Err, tt.err)) {
+			t.Errorf("mock gas %d: outcome mismatch: failed %v, err %v", tt.mockGas, res.Failed, res.Err)
+		}
+	}
+	// Without the mock the real code is executed
+	result = b.multicall(t, []MulticallArgs{newCall(multicallContract, nil)}, MulticallConfig{})
+	if have := result.Calls[0].ReturnData; !bytes.Equal(have, common.LeftPadBytes([]byte{7}, 32)) {
+		t.Errorf("unmocked return data mismatch: have %x", have)
+	}
+	if have := b.state.GetState(mocked, common.Hash{}); have != common.BigToHash(big.NewInt(1)) {
+		t.Errorf("unmocked slot mismatch: have %x", have)
+	}
+}
+
+func TestMulticallTraceContractGas(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// The router calls two contracts, writing one and two fresh slots
+	var (
+		first  = common.HexToAddress("0x3000000000000000000000000000000000000003")
+		second = common.HexToAddress("0x4000000000000000000000000000000000000004")
+	)
+	b.state.SetCode(first, []byte{byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.SSTORE), byte(vm.STOP)})
+	b.state.SetCode(second, []byte{
+		byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x01, byte(vm.SSTORE), byte(vm.STOP),
+	})
+	var code []byte
+	for _, callee := range []common.Address{first, second} {
+		code = append(code, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+			byte(vm.PUSH1), 0x00, byte(vm.PUSH20))
+		code = append(code, callee.Bytes()...)
+		code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP))
+	}
+	b.state.SetCode(multicallContract, append(code, byte(vm.STOP)))
+
+	result := b.multicall(t, []MulticallArgs{newCall(multicallContract, nil)}, MulticallConfig{TraceContractGas: true})
+	res := result.Calls[0]
+	if res.FrameGas != nil {
+		t.Errorf("frame gas reported without being requested")
+	}
+	if len(res.ContractGas) != 3 {
+		t.Fatalf("contract count mismatch: have %d, want 3", len(res.ContractGas))
+	}
+	if have := res.ContractGas[first]; have != 20006 {
+		t.Errorf("first callee gas mismatch: have %d, want %d", have, 20006)
+	}
+	if have := res.ContractGas[second]; have != 40012 {
+		t.Errorf("second callee gas mismatch: have %d, want %d", have, 40012)
+	}
+	// The router's gas includes its callees', so it's all gas used by the call
+	// beyond the intrinsic gas
+	execution := res.GasUsed - hexutil.Uint64(params.TxGas)
+	if have := res.ContractGas[multicallContract]; have != execution {
+		t.Errorf("router gas mismatch: have %d, want %d", have, execution)
+	}
+	result = b.multicall(t, []MulticallArgs{newCall(multicallContract, nil)}, MulticallConfig{TraceFrameGas: true, TraceContractGas: true})
+	res = result.Calls[0]
+
+	// The slots are set by now, so every callee uses less gas than before
+	execution = res.GasUsed - hexutil.Uint64(params.TxGas)
+	if have := res.FrameGas[0].SelfGas + res.ContractGas[first] + res.ContractGas[second]; have != execution {
+		t.Errorf("contract gas sum mismatch: have %d, want %d", have, execution)
+	}
+}
+
+func TestMulticallRecentHeaders(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.header.Number = big.NewInt(10)
+
+	// Supply the headers of blocks 5 to 9
+	var headers []*types.Header
+	for number := int64(5); number < 10; number++ {
+		header := &types.Header{Number: big.NewInt(number), Difficulty: big.NewInt(1), Extra: []byte("recent")}
+		if len(headers) > 0 {
+			header.ParentHash = headers[len(headers)-1].Hash()
+		}
+		headers = append(headers, header)
+	}
+	// The contract returns the hashes of the blocks 1 to 5 blocks back
+	var code []byte
+	for offset := byte(1); offset <= 5; offset++ {
+		code = append(code, byte(vm.PUSH1), offset, byte(vm.NUMBER), byte(vm.SUB), byte(vm.BLOCKHASH),
+			byte(vm.PUSH1), (offset-1)*32, byte(vm.MSTORE))
+	}
+	code = append(code, byte(vm.PUSH1), 5*32, byte(vm.PUSH1), 0x00, byte(vm.RETURN))
+	b.state.SetCode(multicallContract, code)
+
+	result := b.multicall(t, []MulticallArgs{newCall(multicallContract, nil)}, MulticallConfig{RecentHeaders: headers})
+	res := result.Calls[0]
+	if res.Failed {
+		t.Fatalf("call failed: %v", res.Err)
+	}
+	for offset := 1; offset <= 5; offset++ {
+		have := common.BytesToHash(res.ReturnData[(offset-1)*32 : offset*32])
+		if want := headers[len(headers)-offset].Hash(); have != want {
+			t.Errorf("offset %d: hash mismatch: have %x, want %x", offset, have, want)
+		}
+	}
+	// Headers must form a chain below the executed block
+	broken := append([]*types.Header{}, headers...)
+	broken[2] = &types.Header{Number: big.NewInt(7), Difficulty: big.NewInt(1)}
+	if _, err := DoMulticall(context.Background(), b, []MulticallArgs{newCall(multicallContract, nil)}, rpc.LatestBlockNumber, nil, MulticallConfig{RecentHeaders: broken}, vm.Config{}, 0, nil); err == nil {
+		t.Errorf("non-contiguous headers accepted")
+	}
+	b.header.Number = big.NewInt(9)
+	if _, err := DoMulticall(context.Background(), b, []MulticallArgs{newCall(multicallContract, nil)}, rpc.LatestBlockNumber, nil, MulticallConfig{RecentHeaders: headers}, vm.Config{}, 0, nil); err == nil {
+		t.Errorf("headers reaching the executed block accepted")
+	}
+}
+
+func TestMulticallMaxSubcalls(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// The contract calls an empty account as many times as its input says
+	callee := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	code := []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.CALLDATALOAD), // counter
+		byte(vm.JUMPDEST), // pc 3
+		byte(vm.DUP1), byte(vm.ISZERO), byte(vm.PUSH1), 0x32, byte(vm.JUMPI),
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH20),
+	}
+	code = append(code, callee.Bytes()...)
+	code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP),
+		byte(vm.PUSH1), 0x01, byte(vm.SWAP1), byte(vm.SUB), byte(vm.PUSH1), 0x03, byte(vm.JUMP),
+		byte(vm.JUMPDEST), byte(vm.STOP), // pc 0x32
+	)
+	b.state.SetCode(multicallContract, code)
+
+	calls := []MulticallArgs{
+		newCall(multicallContract, common.LeftPadBytes([]byte{3}, 32)),
+		newCall(multicallContract, common.LeftPadBytes([]byte{4}, 32)),
+	}
+	result := b.multicall(t, calls, MulticallConfig{MaxSubcalls: 3})
+	if res := result.Calls[0]; res.Failed || res.SubcallLimitExceeded {
+		t.Errorf("call within the limit failed: %v", res.Err)
+	}
+	if res := result.Calls[1]; !res.Failed || !res.SubcallLimitExceeded || !isError(res.Err, vm.ErrSubcallLimitExceeded) {
+		t.Errorf("call exceeding the limit not rejected: failed %v, flagged %v, err %v", res.Failed, res.SubcallLimitExceeded, res.Err)
+	}
+	// Exceeding the limit in a nested frame fails the whole call, even if the
+	// caller ignores the failure of the frame
+	wrapper := common.HexToAddress("0x4000000000000000000000000000000000000004")
+	code = []byte{
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.CALLDATACOPY),
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH20),
+	}
+	code = append(code, multicallContract.Bytes()...)
+	code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP), byte(vm.STOP))
+	b.state.SetCode(wrapper, code)
+
+	calls = []MulticallArgs{
+		newCall(wrapper, common.LeftPadBytes([]byte{2}, 32)),
+		newCall(wrapper, common.LeftPadBytes([]byte{3}, 32)),
+	}
+	result = b.multicall(t, calls, MulticallConfig{MaxSubcalls: 3})
+	if res := result.Calls[0]; res.Failed || res.SubcallLimitExceeded {
+		t.Errorf("nested calls within the limit failed: %v", res.Err)
+	}
+	if res := result.Calls[1]; !res.Failed || !res.SubcallLimitExceeded || !isError(res.Err, vm.ErrSubcallLimitExceeded) {
+		t.Errorf("nested call exceeding the limit not rejected: failed %v, flagged %v, err %v", res.Failed, res.SubcallLimitExceeded, res.Err)
+	}
+}
+
+func TestMulticallTrackStorage(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// The callee writes a slot and reverts
+	callee := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	b.state.SetCode(callee, []byte{
+		byte(vm.PUSH1), 0x05, byte(vm.PUSH1), 0x00, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.REVERT),
+	})
+	// The contract reads slot 1, writes slots 2 and 3 (twice) and calls the callee
+	b.state.SetState(multicallContract, common.Hash{31: 1}, common.Hash{31: 0x11})
+	code := []byte{
+		byte(vm.PUSH1), 0x01, byte(vm.SLOAD), byte(vm.POP),
+		byte(vm.PUSH1), 0x22, byte(vm.PUSH1), 0x02, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0x33, byte(vm.PUSH1), 0x03, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0x34, byte(vm.PUSH1), 0x03, byte(vm.SSTORE),
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH20),
+	}
+	code = append(code, callee.Bytes()...)
+	code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP), byte(vm.STOP))
+	b.state.SetCode(multicallContract, code)
+
+	result := b.multicall(t, []MulticallArgs{newCall(multicallContract, nil)}, MulticallConfig{TrackStorage: true})
+	want := map[common.Address]map[common.Hash]common.Hash{
+		multicallContract: {
+			{31: 1}: {31: 0x11},
+			{31: 2}: {31: 0x22},
+			{31: 3}: {31: 0x34},
+		},
+		callee: {
+			{}: {},
+		},
+	}
+	have := result.Calls[0].Storage
+	if !reflect.DeepEqual(have, want) {
+		t.Fatalf("storage mismatch: have %x, want %x", have, want)
+	}
+	for addr, slots := range have {
+		for slot, value := range slots {
+			if state := b.state.GetState(addr, slot); state != value {
+				t.Errorf("%x slot %x: value mismatch with the state: have %x, want %x", addr, slot, value, state)
+			}
+		}
+	}
+}
+
+func TestMulticallCollectWitness(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+	b.state.SetBalance(multicallSender, big.NewInt(1000))
+
+	// The reader returns slot 5 of its own storage, plus the balance of the
+	// recipient
+	reader := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	recipient := common.HexToAddress("0x4000000000000000000000000000000000000004")
+	code := []byte{byte(vm.PUSH1), 0x05, byte(vm.SLOAD), byte(vm.PUSH20)}
+	code = append(code, recipient.Bytes()...)
+	code = append(code, byte(vm.BALANCE), byte(vm.ADD), byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.RETURN))
+	b.state.SetCode(reader, code)
+	b.state.SetState(reader, common.Hash{31: 5}, common.Hash{31: 0x10})
+	b.state.SetState(reader, common.Hash{31: 6}, common.Hash{31: 0x20})
+
+	// Execute the batch on a committed state, which can be proven
+	root, err := b.state.Commit(false)
+	if err != nil {
+		t.Fatalf("failed to commit state: %v", err)
+	}
+	if b.state, err = state.New(root, b.state.Database()); err != nil {
+		t.Fatalf("failed to open state: %v", err)
+	}
+	b.header.Root = root
+
+	transfer := newCall(recipient, nil)
+	transfer.Value = (*hexutil.Big)(big.NewInt(100))
+	calls := []MulticallArgs{storeCall(1), newCall(reader, nil), transfer, newCall(reader, nil)}
+	result := b.multicall(t, calls, MulticallConfig{CollectWitness: true})
+
+	witness := result.Witness
+	if witness == nil || witness.Root == nil || *witness.Root != root {
+		t.Fatalf("witness root mismatch")
+	}
+	if account := witness.Accounts[reader]; account == nil || len(account.Storage) != 1 || account.Storage[common.Hash{31: 5}] != (common.Hash{31: 0x10}) {
+		t.Errorf("reader witness mismatch: %+v", account)
+	}
+	if account, ok := witness.Accounts[recipient]; !ok || account != nil {
+		t.Errorf("absent recipient witness mismatch: %+v", account)
+	}
+	// The account proofs are valid against the state root
+	for addr, proof := range witness.Proofs {
+		nodes := memorydb.New()
+		for _, node := range proof.AccountProof {
+			blob := hexutil.MustDecode(node)
+			nodes.Put(crypto.Keccak256(blob), blob)
+		}
+		if _, _, err := trie.VerifyProof(root, crypto.Keccak256(addr.Bytes()), nodes); err != nil {
+			t.Errorf("%x: invalid account proof: %v", addr, err)
+		}
+	}
+	// Executing the batch on the witness only reproduces the results
+	replay := newMulticallBackend(t)
+	for addr, account := range witness.Accounts {
+		if account == nil {
+			continue
+		}
+		replay.state.SetBalance(addr, account.Balance.ToInt())
+		replay.state.SetNonce(addr, uint64(account.Nonce))
+		replay.state.SetCode(addr, account.Code)
+		for key, value := range account.Storage {
+			replay.state.SetState(addr, key, value)
+		}
+	}
+	replayed := replay.multicall(t, calls, MulticallConfig{})
+	for i, res := range replayed.Calls {
+		want := result.Calls[i]
+		if !bytes.Equal(res.ReturnData, want.ReturnData) || res.GasUsed != want.GasUsed || res.Failed != want.Failed || len(res.Logs) != len(want.Logs) {
+			t.Errorf("call %d: replayed outcome mismatch", i)
+		}
+	}
+	if have := replay.state.GetBalance(recipient); have.Cmp(big.NewInt(100)) != 0 {
+		t.Errorf("replayed recipient balance mismatch: have %v, want 100", have)
+	}
+}
+
+func TestMulticallTrackSelfDestructs(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	var (
+		destructing = []byte{byte(vm.PUSH1), 0x00, byte(vm.SELFDESTRUCT)}
+		first       = common.HexToAddress("0x3000000000000000000000000000000000000003")
+		second      = common.HexToAddress("0x4000000000000000000000000000000000000004")
+		reverting   = common.HexToAddress("0x5000000000000000000000000000000000000005")
+	)
+	call := func(callee common.Address) []byte {
+		code := []byte{
+			byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+			byte(vm.PUSH1), 0x00, byte(vm.PUSH20),
+		}
+		code = append(code, callee.Bytes()...)
+		return append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP))
+	}
+	b.state.SetCode(first, destructing)
+	b.state.SetCode(second, destructing)
+
+	// The contract destructs the second contract and then itself, while the
+	// reverting one destructs the second contract to no avail
+	b.state.SetCode(multicallContract, append(call(second), destructing...))
+	b.state.SetCode(reverting, append(call(second), byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.REVERT)))
+
+	calls := []MulticallArgs{
+		newCall(reverting, nil),
+		newCall(first, nil),
+		newCall(multicallContract, nil),
+		newCall(first, nil),
+	}
+	result := b.multicall(t, calls, MulticallConfig{TrackSelfDestructs: true})
+	tests := [][]common.Address{
+		{},
+		{first},
+		{second, multicallContract},
+		{},
+	}
+	for i, want := range tests {
+		res := result.Calls[i]
+		if res.SelfDestructed != (len(want) > 0) {
+			t.Errorf("call %d: flag mismatch: have %v, want %v", i, res.SelfDestructed, len(want) > 0)
+		}
+		if len(res.SelfDestructs) != len(want) {
+			t.Errorf("call %d: destructs mismatch: have %v, want %v", i, res.SelfDestructs, want)
+			continue
+		}
+		for j := range want {
+			if res.SelfDestructs[j] != want[j] {
+				t.Errorf("call %d: destruct %d mismatch: have %v, want %v", i, j, res.SelfDestructs[j], want[j])
+			}
+		}
+	}
+}
+
+func TestMulticallSelector(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, []byte{byte(vm.STOP)})
+
+	create := hexutil.Bytes{byte(vm.PUSH1), 0x00, byte(vm.DUP1), byte(vm.RETURN), 0xff}
+	calls := []MulticallArgs{
+		newCall(multicallContract, []byte{0xa9, 0x05, 0x9c, 0xbb, 0x01, 0x02}),
+		newCall(multicallContract, nil),
+		newCall(multicallContract, []byte{0xa9, 0x05, 0x9c}),
+		{CallArgs: CallArgs{From: &multicallSender, Data: &create}},
+	}
+	result := b.multicall(t, calls, MulticallConfig{})
+	for i, want := range [][]byte{{0xa9, 0x05, 0x9c, 0xbb}, nil, nil, nil} {
+		if have := result.Calls[i].Selector; !bytes.Equal(have, want) {
+			t.Errorf("call %d: selector mismatch: have %x, want %x", i, have, want)
+		}
+	}
+}
+
+func TestMulticallInvariants(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+	b.state.SetState(multicallContract, common.Hash{}, common.BytesToHash([]byte{3}))
+
+	five := common.BytesToHash([]byte{5})
+	invariants := []StorageInvariant{
+		{Address: multicallContract, NonDecreasing: true},
+		{Address: multicallContract, Slot: common.Hash{1}, Unchanged: true},
+		{Address: multicallContract, Value: &five},
+	}
+	calls := []MulticallArgs{
+		newCall(multicallContract, common.LeftPadBytes([]byte{5}, 32)),
+		newCall(multicallContract, common.LeftPadBytes([]byte{2}, 32)),
+		newCall(multicallContract, common.LeftPadBytes([]byte{4}, 32)),
+	}
+	result := b.multicall(t, calls, MulticallConfig{Invariants: invariants})
+	for i, want := range [][]hexutil.Uint64{nil, {0, 2}, {2}} {
+		have := result.Calls[i].ViolatedInvariants
+		if len(have) != len(want) {
+			t.Errorf("call %d: violations mismatch: have %v, want %v", i, have, want)
+			continue
+		}
+		for j := range want {
+			if have[j] != want[j] {
+				t.Errorf("call %d: violation %d mismatch: have %d, want %d", i, j, have[j], want[j])
+			}
+		}
+	}
+}
+
+func TestMulticallTraceCallEdges(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	var (
+		library = common.HexToAddress("0x3000000000000000000000000000000000000003")
+		other   = common.HexToAddress("0x4000000000000000000000000000000000000004")
+	)
+	call := func(op vm.OpCode, to common.Address) []byte {
+		code := []byte{byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00}
+		if op == vm.CALL {
+			code = append(code, byte(vm.PUSH1), 0x00)
+		}
+		code = append(code, byte(vm.PUSH20))
+		code = append(code, to.Bytes()...)
+		return append(code, byte(vm.GAS), byte(op), byte(vm.POP))
+	}
+	// The library queries the other contract, in the context of the contract
+	// delegating to it
+	b.state.SetCode(library, call(vm.STATICCALL, other))
+	b.state.SetCode(other, []byte{byte(vm.STOP)})
+
+	code := call(vm.DELEGATECALL, library)
+	code = append(code, call(vm.CALL, other)...)
+	code = append(code, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.CREATE2), byte(vm.POP))
+	code = append(code, call(vm.CALL, other)...)
+	b.state.SetCode(multicallContract, code)
+
+	created := crypto.CreateAddress2(multicallContract, common.Hash{}, crypto.Keccak256(nil))
+	edges := []CallEdgeArgs{
+		{From: multicallSender, To: multicallContract, Type: "CALL"},
+		{From: multicallContract, To: library, Type: "DELEGATECALL"},
+		{From: multicallContract, To: other, Type: "STATICCALL"},
+		{From: multicallContract, To: other, Type: "CALL"},
+		{From: multicallContract, To: created, Type: "CREATE2"},
+		{From: multicallContract, To: other, Type: "CALL"},
+	}
+	for _, unique := range []bool{false, true} {
+		result := b.multicall(t, []MulticallArgs{newCall(multicallContract, nil)}, MulticallConfig{TraceCallEdges: true, UniqueCallEdges: unique})
+		want := edges
+		if unique {
+			want = edges[:5]
+		}
+		have := result.Calls[0].CallEdges
+		if len(have) != len(want) {
+			t.Fatalf("unique %v: edge count mismatch: have %d, want %d", unique, len(have), len(want))
+		}
+		for i := range want {
+			if have[i] != want[i] {
+				t.Errorf("unique %v: edge %d mismatch: have %+v, want %+v", unique, i, have[i], want[i])
+			}
+		}
+	}
+}
+
+func TestMulticallTraceInitCode(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// Create a contract from one byte more of init code than EIP-3860 allows
+	b.state.SetCode(multicallContract, []byte{
+		byte(vm.PUSH3), 0x00, 0xc0, 0x01, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+		byte(vm.CREATE), byte(vm.POP), byte(vm.STOP),
+	})
+	// Deploy init code right at the limit
+	deploy := newCall(common.Address{}, make([]byte, 49152))
+	deploy.To = nil
+
+	result := b.multicall(t, []MulticallArgs{deploy, newCall(multicallContract, nil)}, MulticallConfig{TraceInitCode: true})
+
+	tests := [][]InitCodeArgs{
+		{{Op: "CREATE", Depth: 1, Size: 49152, Gas: 1536 * 2}},
+		{{Op: "CREATE", Depth: 2, Size: 49153, Gas: 1537 * 2, ExceedsLimit: true}},
+	}
+	for i, want := range tests {
+		if res := result.Calls[i]; res.Failed || !reflect.DeepEqual(res.InitCodes, want) {
+			t.Errorf("call %d: init code mismatch: have %+v, want %+v (failed %v)", i, res.InitCodes, want, res.Failed)
+		}
+	}
+}
+
+func TestMulticallTraceCodeIntrospection(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	target := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	other := common.HexToAddress("0x4000000000000000000000000000000000000004")
+	b.state.SetCode(target, []byte{byte(vm.STOP)})
+
+	// Inspect the size of the target twice, and copy the code of another account
+	var code []byte
+	for i := 0; i < 2; i++ {
+		code = append(code, byte(vm.PUSH20))
+		code = append(code, target.Bytes()...)
+		code = append(code, byte(vm.EXTCODESIZE), byte(vm.POP))
+	}
+	code = append(code, byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH20))
+	code = append(code, other.Bytes()...)
+	code = append(code, byte(vm.EXTCODECOPY), byte(vm.STOP))
+	b.state.SetCode(multicallContract, code)
+
+	result := b.multicall(t, []MulticallArgs{newCall(multicallContract, nil)}, MulticallConfig{TraceCodeIntrospection: true, TraceCallEdges: true})
+
+	want := []CodeIntrospectionArgs{{Address: target, Op: "EXTCODESIZE"}, {Address: other, Op: "EXTCODECOPY"}}
+	if have := result.Calls[0].CodeIntrospections; !reflect.DeepEqual(have, want) {
+		t.Errorf("introspections mismatch: have %+v, want %+v", have, want)
+	}
+	// Looking at the target doesn't make it a callee
+	for _, edge := range result.Calls[0].CallEdges {
+		if edge.To == target {
+			t.Errorf("inspected target reported as callee: %+v", edge)
+		}
+	}
+}
+
+func TestMulticallDetectReentrancy(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	// The contract calls back into its caller, which calls it unless called
+	// with data
+	callback := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	b.state.SetCode(callback, []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH1), 0x00, byte(vm.CALLER), byte(vm.GAS), byte(vm.CALL), byte(vm.POP), byte(vm.STOP),
+	})
+	code := []byte{
+		byte(vm.CALLDATASIZE), byte(vm.PUSH1), 0x26, byte(vm.JUMPI),
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH20),
+	}
+	code = append(code, callback.Bytes()...)
+	code = append(code, byte(vm.GAS), byte(vm.CALL), byte(vm.POP), byte(vm.JUMPDEST), byte(vm.STOP))
+	b.state.SetCode(multicallContract, code)
+
+	// The library delegates to itself unless called with data
+	library := common.HexToAddress("0x4000000000000000000000000000000000000004")
+	b.state.SetCode(library, []byte{
+		byte(vm.CALLDATASIZE), byte(vm.PUSH1), 0x10, byte(vm.JUMPI),
+		byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x01, byte(vm.PUSH1), 0x00,
+		byte(vm.ADDRESS), byte(vm.GAS), byte(vm.DELEGATECALL), byte(vm.POP), byte(vm.JUMPDEST), byte(vm.STOP),
+	})
+	calls := []MulticallArgs{newCall(multicallContract, nil), newCall(library, nil), newCall(callback, nil)}
+	result := b.multicall(t, calls, MulticallConfig{DetectReentrancy: true})
+
+	tests := [][]ReentryArgs{
+		{{Address: multicallContract, Op: "CALL", Depth: 3, OuterDepth: 1}},
+		{{Address: library, Op: "DELEGATECALL", Depth: 2, OuterDepth: 1, Delegate: true}},
+		{},
+	}
+	for i, want := range tests {
+		if have := result.Calls[i].Reentries; !reflect.DeepEqual(have, want) {
+			t.Errorf("call %d: reentries mismatch: have %+v, want %+v", i, have, want)
+		}
+	}
+}
+
+func TestMulticallErrorCodes(t *testing.T) {
+	b := newMulticallBackend(t)
+
+	var (
+		looping   = common.HexToAddress("0x3000000000000000000000000000000000000003")
+		jumping   = common.HexToAddress("0x4000000000000000000000000000000000000004")
+		invalid   = common.HexToAddress("0x5000000000000000000000000000000000000005")
+		underflow = common.HexToAddress("0x6000000000000000000000000000000000000006")
+	)
+	b.state.SetCode(looping, []byte{byte(vm.JUMPDEST), byte(vm.PUSH1), 0x00, byte(vm.JUMP)})
+	b.state.SetCode(jumping, []byte{byte(vm.PUSH1), 0x05, byte(vm.JUMP)})
+	b.state.SetCode(invalid, []byte{0xfe})
+	b.state.SetCode(underflow, []byte{byte(vm.ADD)})
+
+	calls := []MulticallArgs{newCall(looping, nil), newCall(jumping, nil), newCall(invalid, nil), newCall(underflow, nil)}
+	gas := hexutil.Uint64(100000)
+	for i := range calls {
+		calls[i].Gas = &gas
+	}
+
+	result := b.multicall(t, calls, MulticallConfig{})
+	if err := result.Calls[0].Err; !isError(err, vm.ErrOutOfGas) {
+		t.Errorf("out of gas error not preserved: %v", err)
+	}
+	if err := result.Calls[1].Err; !isError(err, vm.ErrInvalidJump) {
+		t.Errorf("invalid jump error not preserved: %v", err)
+	}
+	if err, ok := result.Calls[2].Err.(*CallError); !ok {
+		t.Errorf("invalid opcode error mismatch: %v", result.Calls[2].Err)
+	} else if opErr, ok := err.Err.(*vm.ErrInvalidOpCode); !ok || opErr.OpCode != 0xfe {
+		t.Errorf("invalid opcode error not preserved: %v", err)
+	}
+	for i, want := range []string{"OUT_OF_GAS", "INVALID_JUMP", "INVALID_OPCODE", "STACK_UNDERFLOW"} {
+		if have := result.Calls[i].ErrorCode; have != want {
+			t.Errorf("call %d: error code mismatch: have %q, want %q", i, have, want)
+		}
+	}
+	// Failures before the top level frame executes any code are reported too,
+	// such as creating a contract at an occupied address or calling a mock
+	// without enough gas for it
+	b = newMulticallBackend(t)
+	b.state.SetCode(crypto.CreateAddress(multicallSender, 0), []byte{byte(vm.STOP)})
+
+	create := MulticallArgs{CallArgs: CallArgs{From: &multicallSender, Data: &hexutil.Bytes{byte(vm.STOP)}}}
+	mock := newCall(looping, nil)
+	mock.Mocks = map[common.Address]hexutil.Bytes{looping: nil}
+	mock.MockGas = 100000
+	mock.Gas = &gas
+
+	result = b.multicall(t, []MulticallArgs{create, mock}, MulticallConfig{})
+	for i, want := range []string{"CONTRACT_ADDRESS_COLLISION", "OUT_OF_GAS"} {
+		if res := result.Calls[i]; !res.Failed || res.Err == nil || res.ErrorCode != want {
+			t.Errorf("call %d: error mismatch: failed %v, err %v, code %q, want %q", i, res.Failed, res.Err, res.ErrorCode, want)
+		}
+	}
+}
+
+// counterPrecompile is a precompile returning a counter increased on every
+// invocation, which makes executions calling it nondeterministic.
+type counterPrecompile struct{ count byte }
+
+func (p *counterPrecompile) RequiredGas(input []byte) uint64 { return 1 }
+
+func (p *counterPrecompile) Run(input []byte) ([]byte, error) {
+	p.count++
+	return common.LeftPadBytes([]byte{p.count}, 32), nil
+}
+
+func TestMulticallVerifyDeterminism(t *testing.T) {
+	counter := common.HexToAddress("0x0000000000000000000000000000000000000100")
+	vm.PrecompiledContractsByzantium[counter] = new(counterPrecompile)
+	defer delete(vm.PrecompiledContractsByzantium, counter)
+
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+
+	// The contract stores the output of the counter
+	storing := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	b.state.SetCode(storing, []byte{
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00, byte(vm.PUSH1), 0x00,
+		byte(vm.PUSH2), 0x01, 0x00, byte(vm.GAS), byte(vm.STATICCALL), byte(vm.POP),
+		byte(vm.PUSH1), 0x00, byte(vm.MLOAD), byte(vm.PUSH1), 0x00, byte(vm.SSTORE),
+	})
+	calls := []MulticallArgs{storeCall(1), newCall(counter, nil), newCall(storing, nil)}
+	result := b.multicall(t, calls, MulticallConfig{VerifyDeterminism: true})
+
+	for i, want := range [][]string{nil, {"returnData"}, {"state"}} {
+		res := result.Calls[i]
+		if res.Nondeterministic != (len(want) > 0) || !reflect.DeepEqual(res.Divergences, want) {
+			t.Errorf("call %d: divergence mismatch: have %v, want %v", i, res.Divergences, want)
+		}
+	}
+	// Only the second execution takes effect
+	if have := b.state.GetState(multicallContract, common.Hash{}); have != common.BytesToHash([]byte{1}) {
+		t.Errorf("slot mismatch: have %x", have)
+	}
+	if have := b.state.GetState(storing, common.Hash{}); have != common.BytesToHash([]byte{4}) {
+		t.Errorf("stored counter mismatch: have %x, want 4", have)
+	}
+}
+
+// benchmarkMulticallAnalysis executes a call to a large contract, whose JUMPDEST
+// analysis dominates the execution, with the EVM configuration returned by
+// vmCfg for every iteration.
+func benchmarkMulticallAnalysis(b *testing.B, vmCfg func() vm.Config) {
+	backend := newMulticallBackend(b)
+
+	// Jump across 24KB of pushes right away
+	code := []byte{byte(vm.PUSH2), 0x5d, 0xc4, byte(vm.JUMP)}
+	for len(code) < 0x5dc4 {
+		code = append(code, byte(vm.PUSH1), byte(vm.JUMPDEST))
+	}
+	code = append(code, byte(vm.JUMPDEST), byte(vm.STOP))
+	backend.state.SetCode(multicallContract, code)
+	WarmAnalysisCache([][]byte{code})
+
+	calls := []MulticallArgs{newCall(multicallContract, nil)}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		cfg := vmCfg()
+		b.StartTimer()
+		result, err := DoMulticall(context.Background(), backend, calls, rpc.LatestBlockNumber, nil, MulticallConfig{}, cfg, 0, nil)
+		if err != nil || result.Calls[0].Failed {
+			b.Fatalf("multicall failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkMulticallAnalysisCache(b *testing.B) {
+	b.Run("cold", func(b *testing.B) {
+		benchmarkMulticallAnalysis(b, func() vm.Config {
+			return vm.Config{JumpdestCache: vm.NewJumpdestCache(1)}
+		})
+	})
+	b.Run("warm", func(b *testing.B) {
+		benchmarkMulticallAnalysis(b, func() vm.Config { return vm.Config{} })
+	})
+}
+
+func TestMulticallMemoizeReads(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, storeOrRevertCode)
+
+	reader := common.HexToAddress("0x3000000000000000000000000000000000000003")
+	b.state.SetCode(reader, []byte{
+		byte(vm.PUSH1), 0x00, byte(vm.SLOAD), byte(vm.PUSH1), 0x00, byte(vm.MSTORE),
+		byte(vm.PUSH1), 0x20, byte(vm.PUSH1), 0x00, byte(vm.RETURN),
+	})
+	b.state.SetState(reader, common.Hash{}, common.Hash{31: 0x42})
+
+	read := newCall(reader, nil)
+	calls := []MulticallArgs{read, read, storeCall(1), read, read}
+
+	logger := vm.NewStructLogger(nil)
+	vmCfg := vm.Config{Debug: true, Tracer: logger}
+	result, err := DoMulticall(context.Background(), b, calls, rpc.LatestBlockNumber, nil, MulticallConfig{MemoizeReads: true}, vmCfg, 0, nil)
+	if err != nil {
+		t.Fatalf("multicall failed: %v", err)
+	}
+	for i, memoized := range []bool{false, true, false, false, true} {
+		if have := result.Calls[i].Memoized; have != memoized {
+			t.Errorf("call %d: memoization mismatch: have %v, want %v", i, have, memoized)
+		}
+	}
+	for _, pair := range [][2]int{{0, 1}, {3, 4}} {
+		first, second := result.Calls[pair[0]], result.Calls[pair[1]]
+		if !bytes.Equal(first.ReturnData, second.ReturnData) || first.GasUsed != second.GasUsed || first.Failed != second.Failed {
+			t.Errorf("calls %d and %d: result mismatch", pair[0], pair[1])
+		}
+	}
+	// Only the non-memoized calls were executed, which still increase the
+	// sender's nonce
+	var executions int
+	for _, log := range logger.StructLogs() {
+		if log.Depth == 1 && log.Pc == 0 {
+			executions++
+		}
+	}
+	if executions != 3 {
+		t.Errorf("execution count mismatch: have %d, want 3", executions)
+	}
+	if have := b.state.GetNonce(multicallSender); have != uint64(len(calls)) {
+		t.Errorf("sender nonce mismatch: have %d, want %d", have, len(calls))
+	}
+	// Invariants violated by a memoized read are reported once
+	five := common.BytesToHash([]byte{5})
+	config := MulticallConfig{MemoizeReads: true, Invariants: []StorageInvariant{{Address: reader, Value: &five}}}
+
+	result = b.multicall(t, []MulticallArgs{read, read}, config)
+	if !result.Calls[1].Memoized {
+		t.Fatalf("read not memoized")
+	}
+	for i, res := range result.Calls {
+		if len(res.ViolatedInvariants) != 1 || res.ViolatedInvariants[0] != 0 {
+			t.Errorf("call %d: violations mismatch: have %v, want [0]", i, res.ViolatedInvariants)
+		}
+	}
+}
+
+func TestMulticallSteps(t *testing.T) {
+	b := newMulticallBackend(t)
+	b.state.SetCode(multicallContract, []byte{byte(vm.PUSH1), 0x02, byte(vm.PUSH1), 0x03, byte(vm.ADD), byte(vm.STOP)})
+
+	// Consume all steps of a short contract
+	steps, err := MulticallSteps(context.Background(), b, newCall(multicallContract, nil).CallArgs, rpc.LatestBlockNumber, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to prepare steps: %v", err)
+	}
+	var ops []vm.OpCode
+	steps(func(step vm.StructLog) bool {
+		ops = append(ops, step.Op)
+		if step.Op == vm.STOP && (len(step.Stack) != 1 || step.Stack[0].Int64() != 5) {
+			t.Errorf("final stack mismatch: %v", step.Stack)
+		}
+		return true
+	})
+	if want := []vm.OpCode{vm.PUSH1, vm.PUSH1, vm.ADD, vm.STOP}; !reflect.DeepEqual(ops, want) {
+		t.Errorf("steps mismatch: have %v, want %v", ops, want)
+	}
+	// Consuming the steps again yields nothing
+	steps(func(step vm.StructLog) bool {
+		t.Errorf("step yielded again: %v", step.Op)
+		return true
+	})
+	// A call rejected before executing ends with the error alone
+	broke := newCall(multicallContract, nil)
+	pauper := common.HexToAddress("0x7000000000000000000000000000000000000007")
+	broke.From = &pauper
+	broke.Value = (*hexutil.Big)(big.NewInt(1))
+	if steps, err = MulticallSteps(context.Background(), b, broke.CallArgs, rpc.LatestBlockNumber, nil, nil, nil); err != nil {
+		t.Fatalf("failed to prepare steps: %v", err)
+	}
+	var failures []vm.StructLog
+	steps(func(step vm.StructLog) bool {
+		failures = append(failures, step)
+		return true
+	})
+	if len(failures) != 1 || failures[0].Depth != 0 || failures[0].Err != vm.ErrInsufficientBalance {
+		t.Errorf("rejected call steps mismatch: %+v", failures)
+	}
+	// Step through an endless loop and stop early
+	b.state.SetCode(multicallContract, []byte{byte(vm.JUMPDEST), byte(vm.PUSH1), 0x00, byte(vm.JUMP)})
+	goroutines := runtime.NumGoroutine()
+
+	call := newCall(multicallContract, nil)
+	gas := hexutil.Uint64(50000000)
+	call.Gas = &gas
+	if steps, err = MulticallSteps(context.Background(), b, call.CallArgs, rpc.LatestBlockNumber, nil, &vm.LogConfig{DisableMemory: true, DisableStorage: true}, nil); err != nil {
+		t.Fatalf("failed to prepare steps: %v", err)
+	}
+	var pcs []uint64
+	steps(func(step vm.StructLog) bool {
+		pcs = append(pcs, step.Pc)
+		return len(pcs) < 5
+	})
+	if want := []uint64{0, 1, 3, 0, 1}; !reflect.DeepEqual(pcs, want) {
+		t.Errorf("program counters mismatch: have %v, want %v", pcs, want)
+	}
+	steps(func(step vm.StructLog) bool {
+		t.Errorf("step yielded after stopping: %v", step.Op)
+		return false
+	})
+	// The abandoned execution releases its goroutines
+	for deadline := time.Now().Add(time.Second); runtime.NumGoroutine() > goroutines; {
+		if time.Now().After(deadline) {
+			t.Fatalf("execution not abandoned: %d goroutines, want %d", runtime.NumGoroutine(), goroutines)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
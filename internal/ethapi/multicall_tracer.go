@@ -0,0 +1,1228 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// resultTracer is a tracer collecting statistics about a single multicall call,
+// which are reported by filling in the corresponding fields of its result.
+type resultTracer interface {
+	vm.Tracer
+
+	// report fills in the collected statistics once the call has finished.
+	report(res *ExecutionResultArgs)
+}
+
+// multiTracer is a tracer forwarding all events to a list of tracers.
+type multiTracer []vm.Tracer
+
+func (t multiTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	for _, tracer := range t {
+		if err := tracer.CaptureStart(from, to, create, input, gas, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t multiTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	for _, tracer := range t {
+		if err := tracer.CaptureState(env, pc, op, gas, cost, memory, stack, contract, depth, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t multiTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	for _, tracer := range t {
+		if err := tracer.CaptureFault(env, pc, op, gas, cost, memory, stack, contract, depth, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t multiTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	for _, tracer := range t {
+		if err := tracer.CaptureEnd(output, gasUsed, d, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withTracers returns a copy of the vm config, which additionally runs the
+// given result tracers next to the tracer already configured, if any.
+func withTracers(vmCfg vm.Config, tracers []resultTracer) vm.Config {
+	if len(tracers) == 0 {
+		return vmCfg
+	}
+	var multi multiTracer
+	if vmCfg.Debug && vmCfg.Tracer != nil {
+		multi = append(multi, vmCfg.Tracer)
+	}
+	for _, tracer := range tracers {
+		multi = append(multi, tracer)
+	}
+	vmCfg.Debug, vmCfg.Tracer = true, multi
+	return vmCfg
+}
+
+// clearedSlot is a storage slot set to zero by a call.
+type clearedSlot struct {
+	addr common.Address
+	slot common.Hash
+}
+
+// refundTracer counts the storage slots cleared during a call, which are
+// eligible for a gas refund. Slots cleared by frames which are reverted later
+// on aren't counted.
+type refundTracer struct {
+	refunds  func() uint64 // Retrieves the refund counter of the call
+	msgGas   uint64        // Gas limit of the call's message
+	startGas uint64        // Gas available after paying the intrinsic gas
+	usedGas  uint64        // Gas used by the execution, before refunds
+
+	cleared []clearedSlot // Slots cleared by the frames not reverted so far
+	frames  []int         // Number of slots cleared before entering every active frame
+}
+
+func newRefundTracer(refunds func() uint64, msgGas uint64) *refundTracer {
+	return &refundTracer{
+		refunds: refunds,
+		msgGas:  msgGas,
+	}
+}
+
+func (t *refundTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.startGas = gas
+	return nil
+}
+
+func (t *refundTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	// Resuming a frame means the frames it called have returned, with their
+	// outcome on top of the stack. Only frames which failed push a zero, either
+	// as the status of a call or as the address of a creation.
+	for len(t.frames) > depth {
+		if stack.Back(0).Sign() == 0 {
+			t.cleared = t.cleared[:t.frames[len(t.frames)-1]]
+		}
+		t.frames = t.frames[:len(t.frames)-1]
+	}
+	for len(t.frames) < depth {
+		t.frames = append(t.frames, len(t.cleared))
+	}
+	if op != vm.SSTORE || err != nil {
+		return nil
+	}
+	// The state is captured before the operation is executed, so the current
+	// value is still the one to be overwritten.
+	var (
+		addr = contract.Address()
+		slot = common.BigToHash(stack.Back(0))
+	)
+	if stack.Back(1).Sign() != 0 || env.StateDB.GetState(addr, slot) == (common.Hash{}) {
+		return nil
+	}
+	t.cleared = append(t.cleared, clearedSlot{addr, slot})
+	return nil
+}
+
+func (t *refundTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *refundTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	t.usedGas = gasUsed
+	if err != nil {
+		t.cleared = nil
+	}
+	return nil
+}
+
+func (t *refundTracer) report(res *ExecutionResultArgs) {
+	cleared := make(map[clearedSlot]struct{})
+	for _, slot := range t.cleared {
+		cleared[slot] = struct{}{}
+	}
+	// The refund is capped to a fraction of the gas used by the whole
+	// transaction, the intrinsic gas included. This chain caps it to a half,
+	// EIP-3529 lowers the cap to a fifth.
+	var (
+		refund     = t.refunds()
+		used       = t.msgGas - t.startGas + t.usedGas
+		capped     = refund
+		capped3529 = refund
+	)
+	if limit := used / 2; capped > limit {
+		capped = limit
+	}
+	if limit := used / 5; capped3529 > limit {
+		capped3529 = limit
+	}
+	slotsCleared, cappedRefund, cappedRefund3529 := hexutil.Uint64(len(cleared)), hexutil.Uint64(capped), hexutil.Uint64(capped3529)
+	res.SlotsCleared, res.CappedRefund, res.CappedRefundEIP3529 = &slotsCleared, &cappedRefund, &cappedRefund3529
+}
+
+// repricingTracer re-prices the gas used by a call against the gas schedule of
+// a reference fork. Only the static portion of the gas charged for opcodes is
+// taken into account, along with the intrinsic gas.
+type repricingTracer struct {
+	active    *vm.JumpTable // Instruction set the call is executed with
+	reference *vm.JumpTable // Instruction set to re-price the opcodes with
+	delta     int64         // Difference between the re-priced and actual gas
+}
+
+func newRepricingTracer(msg core.Message, active params.Rules, activeSet *vm.JumpTable, reference params.Rules) *repricingTracer {
+	var (
+		referenceSet = vm.LookupInstructionSet(reference)
+		creation     = msg.To() == nil
+	)
+	activeGas, _ := core.IntrinsicGas(msg.Data(), creation, active.IsHomestead)
+	referenceGas, _ := core.IntrinsicGas(msg.Data(), creation, reference.IsHomestead)
+
+	return &repricingTracer{
+		active:    activeSet,
+		reference: &referenceSet,
+		delta:     int64(referenceGas) - int64(activeGas),
+	}
+}
+
+func (t *repricingTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+func (t *repricingTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if err == nil {
+		t.delta += int64(t.reference.ConstantGas(op)) - int64(t.active.ConstantGas(op))
+	}
+	return nil
+}
+
+func (t *repricingTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *repricingTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+func (t *repricingTracer) report(res *ExecutionResultArgs) {
+	used := int64(res.GasUsed) + t.delta
+	if used < 0 {
+		used = 0
+	}
+	referenceGasUsed := hexutil.Uint64(used)
+	res.ReferenceGasUsed = &referenceGasUsed
+}
+
+// callGasTracer records the gas accounting of every CALL-family operation
+// executed by a call, to analyse how gas is forwarded to callees.
+type callGasTracer struct {
+	calls     []CallGasArgs
+	pending   []int    // Indexes of the calls awaiting their return, by increasing depth
+	remaining []uint64 // Gas left to the caller of each call, after paying for it
+}
+
+func newCallGasTracer() *callGasTracer {
+	return &callGasTracer{calls: []CallGasArgs{}}
+}
+
+func (t *callGasTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+func (t *callGasTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	// Resolve the calls made from this or deeper frames. The gas returned is
+	// only observable if the caller continues executing, as frames may end
+	// without executing a further operation.
+	for len(t.pending) > 0 {
+		idx := t.pending[len(t.pending)-1]
+		if t.calls[idx].Depth < depth {
+			break
+		}
+		if t.calls[idx].Depth == depth {
+			returned := hexutil.Uint64(gas - t.remaining[idx])
+			t.calls[idx].GasReturned = &returned
+		}
+		t.pending = t.pending[:len(t.pending)-1]
+	}
+	if err != nil {
+		return nil
+	}
+	switch op {
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+	default:
+		return nil
+	}
+	// Value transfers are granted a stipend on top of the forwarded gas
+	var stipend uint64
+	if (op == vm.CALL || op == vm.CALLCODE) && stack.Back(2).Sign() != 0 {
+		stipend = params.CallStipend
+	}
+	t.pending = append(t.pending, len(t.calls))
+	t.remaining = append(t.remaining, gas-cost)
+	t.calls = append(t.calls, CallGasArgs{
+		Op:           op.String(),
+		Depth:        depth,
+		PC:           hexutil.Uint64(pc),
+		To:           common.BigToAddress(stack.Back(1)),
+		GasAvailable: hexutil.Uint64(gas),
+		GasForwarded: hexutil.Uint64(env.CallGas() + stipend),
+		Stipend:      hexutil.Uint64(stipend),
+	})
+	return nil
+}
+
+func (t *callGasTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *callGasTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+func (t *callGasTracer) report(res *ExecutionResultArgs) {
+	res.CallGas = t.calls
+}
+
+// memoryTracer tracks the memory expansion of all call frames executed by a
+// call, along with the gas charged for it.
+type memoryTracer struct {
+	frames    []uint64 // Memory size in words of the active call frames, by depth
+	peakWords uint64   // Largest memory size of any call frame, in words
+	gas       uint64   // Total gas charged for expanding memory
+}
+
+func newMemoryTracer() *memoryTracer {
+	return new(memoryTracer)
+}
+
+// memoryGas returns the gas charged for expanding memory to the given size.
+func memoryGas(words uint64) uint64 {
+	return words*params.MemoryGas + words*words/params.QuadCoeffDiv
+}
+
+func (t *memoryTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+func (t *memoryTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	// Frames deeper than the current one have returned, and entering a new frame
+	// starts with empty memory.
+	if depth <= len(t.frames) {
+		t.frames = t.frames[:depth]
+	}
+	for len(t.frames) < depth {
+		t.frames = append(t.frames, 0)
+	}
+	// The memory is captured after being resized for the operation
+	words := uint64(memory.Len()) / 32
+	if prev := t.frames[depth-1]; words > prev {
+		t.gas += memoryGas(words) - memoryGas(prev)
+		t.frames[depth-1] = words
+	}
+	if words > t.peakWords {
+		t.peakWords = words
+	}
+	return nil
+}
+
+func (t *memoryTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *memoryTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+func (t *memoryTracer) report(res *ExecutionResultArgs) {
+	peakWords, gas := hexutil.Uint64(t.peakWords), hexutil.Uint64(t.gas)
+	res.PeakMemWords, res.MemExpansionGas = &peakWords, &gas
+}
+
+// accessTracer counts the storage slots and accounts a call touches for the
+// first time, which EIP-2929 prices as cold accesses. The accessed items are
+// tracked per call, as their warmth only lasts for a single transaction.
+//
+// Note, the gas schedule of the executing fork is not affected, the counts are
+// merely an indication of the cost of the call under EIP-2929.
+type accessTracer struct {
+	accounts map[common.Address]struct{}
+	slots    map[common.Address]map[common.Hash]struct{}
+
+	warmCoinbase bool // Whether the coinbase is still to be warmed up, as by EIP-3651
+
+	coldSloads   uint64
+	coldAccounts uint64
+
+	list    []AccessTupleArgs      // Access list avoiding the cold accesses, in order of access
+	entries map[common.Address]int // Index of the entry of every address in the access list
+}
+
+func newAccessTracer(msg core.Message, rules params.Rules, warmCoinbase bool, warmAddrs []common.Address, warmSlots map[common.Address][]common.Hash) *accessTracer {
+	t := &accessTracer{
+		accounts:     make(map[common.Address]struct{}),
+		slots:        make(map[common.Address]map[common.Hash]struct{}),
+		warmCoinbase: warmCoinbase,
+		list:         []AccessTupleArgs{},
+		entries:      make(map[common.Address]int),
+	}
+	// The sender, the recipient and the precompiles are warm from the start
+	t.accounts[msg.From()] = struct{}{}
+	if msg.To() != nil {
+		t.accounts[*msg.To()] = struct{}{}
+	}
+	for addr := range activePrecompiles(rules) {
+		t.accounts[addr] = struct{}{}
+	}
+	// Accounts and slots accessed ahead of the call are warm as well
+	for _, addr := range warmAddrs {
+		t.touchAccount(addr)
+	}
+	for addr, slots := range warmSlots {
+		for _, slot := range slots {
+			t.touchSlot(addr, slot)
+		}
+	}
+	return t
+}
+
+// touchAccount marks the account as accessed, returning whether it was cold.
+func (t *accessTracer) touchAccount(addr common.Address) bool {
+	if _, ok := t.accounts[addr]; ok {
+		return false
+	}
+	t.accounts[addr] = struct{}{}
+	return true
+}
+
+// touchSlot marks the storage slot as accessed, returning whether it was cold.
+func (t *accessTracer) touchSlot(addr common.Address, slot common.Hash) bool {
+	if _, ok := t.slots[addr][slot]; ok {
+		return false
+	}
+	if t.slots[addr] == nil {
+		t.slots[addr] = make(map[common.Hash]struct{})
+	}
+	t.slots[addr][slot] = struct{}{}
+	return true
+}
+
+// listed returns the entry of the account in the access list, adding it if it
+// isn't listed yet.
+func (t *accessTracer) listed(addr common.Address) *AccessTupleArgs {
+	idx, ok := t.entries[addr]
+	if !ok {
+		idx = len(t.list)
+		t.entries[addr] = idx
+		t.list = append(t.list, AccessTupleArgs{Address: addr, StorageKeys: []common.Hash{}})
+	}
+	return &t.list[idx]
+}
+
+func (t *accessTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	// Contracts being created are warm
+	t.accounts[to] = struct{}{}
+	return nil
+}
+
+func (t *accessTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	// The coinbase is only known from the block context of the first operation
+	if t.warmCoinbase {
+		t.accounts[env.Coinbase] = struct{}{}
+		t.warmCoinbase = false
+	}
+	if err != nil {
+		return nil
+	}
+	switch op {
+	case vm.SLOAD:
+		if slot := common.BigToHash(stack.Back(0)); t.touchSlot(contract.Address(), slot) {
+			entry := t.listed(contract.Address())
+			entry.StorageKeys = append(entry.StorageKeys, slot)
+			t.coldSloads++
+		}
+	case vm.SSTORE:
+		// Writes warm the slot up, but are not counted as loads
+		t.touchSlot(contract.Address(), common.BigToHash(stack.Back(0)))
+
+	case vm.BALANCE, vm.EXTCODESIZE, vm.EXTCODECOPY, vm.EXTCODEHASH, vm.SELFDESTRUCT:
+		if addr := common.BigToAddress(stack.Back(0)); t.touchAccount(addr) {
+			t.listed(addr)
+			t.coldAccounts++
+		}
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		if addr := common.BigToAddress(stack.Back(1)); t.touchAccount(addr) {
+			t.listed(addr)
+			t.coldAccounts++
+		}
+	}
+	return nil
+}
+
+func (t *accessTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *accessTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+func (t *accessTracer) report(res *ExecutionResultArgs) {
+	coldSloads, coldAccounts := hexutil.Uint64(t.coldSloads), hexutil.Uint64(t.coldAccounts)
+	res.ColdSloads, res.ColdAccountAccesses = &coldSloads, &coldAccounts
+	res.AccessList = t.list
+}
+
+// frameGasTracer attributes the gas used by a call to the call frames it
+// executed, separating the gas a frame spent itself from the gas spent by the
+// frames it called.
+//
+// The tracer only observes operations, so the gas used by a frame is derived
+// from the gas returned to its caller once the caller resumes execution.
+type frameGasTracer struct {
+	eip150    bool // Whether call frames are only passed 63/64 of the available gas
+	frameGas  bool // Whether to report the gas used by every frame
+	contracts bool // Whether to report the gas used by every contract
+
+	frames  []FrameGasArgs // Call frames in the order of entry
+	parents []int          // Index of the parent of each frame, -1 for the top frame
+	open    []openFrame    // Call frames still executing, by increasing depth
+}
+
+// openFrame tracks the gas of a call frame, which hasn't returned yet.
+type openFrame struct {
+	index     int    // Index of the frame within the traced frames
+	depth     int    // Call depth the frame is executed at
+	forwarded uint64 // Gas passed to the frame, stipend included
+	left      uint64 // Gas left to the caller while the frame executes
+	remaining uint64 // Gas the frame had left after its latest operation
+	executed  bool   // Whether the frame executed any operation
+	faulted   bool   // Whether the frame was aborted by an error
+}
+
+func newFrameGasTracer(rules params.Rules, frameGas, contracts bool) *frameGasTracer {
+	return &frameGasTracer{eip150: rules.IsEIP150, frameGas: frameGas, contracts: contracts, frames: []FrameGasArgs{}}
+}
+
+// enter opens a new call frame at the given depth.
+func (t *frameGasTracer) enter(op vm.OpCode, to common.Address, depth int, forwarded, left uint64) {
+	parent := -1
+	if len(t.open) > 0 {
+		parent = t.open[len(t.open)-1].index
+	}
+	t.open = append(t.open, openFrame{index: len(t.frames), depth: depth, forwarded: forwarded, left: left})
+	t.frames = append(t.frames, FrameGasArgs{Op: op.String(), To: to, Depth: depth})
+	t.parents = append(t.parents, parent)
+}
+
+// exit closes the innermost open call frame. If the caller resumed, the gas used
+// by the frame is derived from the gas returned, otherwise from the gas left by
+// the frame's last operation.
+func (t *frameGasTracer) exit(resumed bool, gas uint64) {
+	frame := t.open[len(t.open)-1]
+	t.open = t.open[:len(t.open)-1]
+
+	used := frame.forwarded
+	switch {
+	case resumed:
+		used -= gas - frame.left
+	case frame.executed && !frame.faulted:
+		used -= frame.remaining
+	}
+	t.frames[frame.index].TotalGas = hexutil.Uint64(used)
+}
+
+func (t *frameGasTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	op := vm.CALL
+	if create {
+		op = vm.CREATE
+	}
+	t.enter(op, to, 1, gas, 0)
+	return nil
+}
+
+func (t *frameGasTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	// Close the frames which returned, the direct callee of this frame resuming
+	for len(t.open) > 0 && t.open[len(t.open)-1].depth > depth {
+		t.exit(t.open[len(t.open)-1].depth == depth+1, gas)
+	}
+	if len(t.open) == 0 {
+		return nil
+	}
+	frame := &t.open[len(t.open)-1]
+	if err != nil {
+		frame.faulted = true
+		return nil
+	}
+	// The address of a contract being created is only known once it executes
+	if !frame.executed && t.frames[frame.index].To == (common.Address{}) {
+		t.frames[frame.index].To = contract.Address()
+	}
+	frame.executed, frame.remaining = true, gas-cost
+
+	switch op {
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		forwarded := env.CallGas()
+		if (op == vm.CALL || op == vm.CALLCODE) && stack.Back(2).Sign() != 0 {
+			forwarded += params.CallStipend
+		}
+		t.enter(op, common.BigToAddress(stack.Back(1)), depth+1, forwarded, gas-cost)
+
+	case vm.CREATE, vm.CREATE2:
+		forwarded := gas - cost
+		if t.eip150 {
+			forwarded -= forwarded / 64
+		}
+		t.enter(op, common.Address{}, depth+1, forwarded, gas-cost-forwarded)
+	}
+	return nil
+}
+
+func (t *frameGasTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	for len(t.open) > 0 && t.open[len(t.open)-1].depth > depth {
+		t.exit(t.open[len(t.open)-1].depth == depth+1, gas)
+	}
+	if len(t.open) > 0 {
+		t.open[len(t.open)-1].faulted = true
+	}
+	return nil
+}
+
+func (t *frameGasTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	for len(t.open) > 1 {
+		t.exit(false, 0)
+	}
+	if len(t.open) == 1 {
+		t.open = t.open[:0]
+		t.frames[0].TotalGas = hexutil.Uint64(gasUsed)
+	}
+	return nil
+}
+
+func (t *frameGasTracer) report(res *ExecutionResultArgs) {
+	for i := range t.frames {
+		t.frames[i].SelfGas = t.frames[i].TotalGas
+	}
+	for i, parent := range t.parents {
+		if parent < 0 {
+			continue
+		}
+		// Gas used by frames which didn't resume their caller is estimated, so
+		// guard against attributing more gas to the callees than the caller used.
+		if t.frames[parent].SelfGas < t.frames[i].TotalGas {
+			t.frames[parent].SelfGas = 0
+		} else {
+			t.frames[parent].SelfGas -= t.frames[i].TotalGas
+		}
+	}
+	if t.frameGas {
+		res.FrameGas = t.frames
+	}
+	if t.contracts {
+		res.ContractGas = t.contractGas()
+	}
+}
+
+// contractGas sums the total gas used by the frames of every called contract.
+// Frames nested within a frame of the same contract are already included in
+// the outer one's total, so they are skipped.
+func (t *frameGasTracer) contractGas() map[common.Address]hexutil.Uint64 {
+	gas := make(map[common.Address]hexutil.Uint64)
+	for i, frame := range t.frames {
+		nested := false
+		for parent := t.parents[i]; parent >= 0 && !nested; parent = t.parents[parent] {
+			nested = t.frames[parent].To == frame.To
+		}
+		if !nested {
+			gas[frame.To] += frame.TotalGas
+		}
+	}
+	return gas
+}
+
+// streamTracer writes the struct logs of a call to a stream as they are
+// produced, instead of collecting them in memory.
+type streamTracer struct {
+	*vm.JSONLogger
+}
+
+func newStreamTracer(cfg *vm.LogConfig, w io.Writer) *streamTracer {
+	return &streamTracer{vm.NewJSONLogger(cfg, w)}
+}
+
+func (t *streamTracer) report(res *ExecutionResultArgs) {
+	res.TraceStreamed = true
+}
+
+// gasOverrideTracer sums up the difference in gas charged for the opcodes whose
+// gas cost was overridden.
+type gasOverrideTracer struct {
+	deltas map[vm.OpCode]int64 // Difference between the overridden and original cost
+	delta  int64               // Gas charged on top of the original cost
+}
+
+func newGasOverrideTracer(deltas map[vm.OpCode]int64) *gasOverrideTracer {
+	return &gasOverrideTracer{deltas: deltas}
+}
+
+func (t *gasOverrideTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+func (t *gasOverrideTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if err == nil {
+		t.delta += t.deltas[op]
+	}
+	return nil
+}
+
+func (t *gasOverrideTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *gasOverrideTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+func (t *gasOverrideTracer) report(res *ExecutionResultArgs) {
+	res.GasOverrideDelta = (*hexutil.Big)(big.NewInt(t.delta))
+}
+
+// selfDestructTracer collects the contracts which self-destructed during a
+// call. Self-destructs within frames that were reverted afterwards are dropped
+// by checking the state once the call has finished.
+type selfDestructTracer struct {
+	db        vm.StateDB
+	destructs []common.Address // Contracts executing SELFDESTRUCT, in order
+}
+
+func newSelfDestructTracer(db vm.StateDB) *selfDestructTracer {
+	return &selfDestructTracer{db: db}
+}
+
+func (t *selfDestructTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+func (t *selfDestructTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if op == vm.SELFDESTRUCT && err == nil {
+		t.destructs = append(t.destructs, contract.Address())
+	}
+	return nil
+}
+
+func (t *selfDestructTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *selfDestructTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+func (t *selfDestructTracer) report(res *ExecutionResultArgs) {
+	var (
+		seen      = make(map[common.Address]bool)
+		destructs = []common.Address{}
+	)
+	for _, addr := range t.destructs {
+		if !seen[addr] && t.db.HasSuicided(addr) {
+			destructs = append(destructs, addr)
+		}
+		seen[addr] = true
+	}
+	res.SelfDestructed = len(destructs) > 0
+	res.SelfDestructs = destructs
+}
+
+// storageTracer collects the storage slots a call reads or writes. Their values
+// are looked up once the call has finished, so that writes reverted afterwards
+// are reflected.
+type storageTracer struct {
+	db    vm.StateDB
+	slots map[common.Address]map[common.Hash]struct{}
+}
+
+func newStorageTracer(db vm.StateDB) *storageTracer {
+	return &storageTracer{db: db, slots: make(map[common.Address]map[common.Hash]struct{})}
+}
+
+func (t *storageTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+func (t *storageTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if (op == vm.SLOAD || op == vm.SSTORE) && err == nil {
+		addr := contract.Address()
+		if t.slots[addr] == nil {
+			t.slots[addr] = make(map[common.Hash]struct{})
+		}
+		t.slots[addr][common.BigToHash(stack.Back(0))] = struct{}{}
+	}
+	return nil
+}
+
+func (t *storageTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *storageTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+func (t *storageTracer) report(res *ExecutionResultArgs) {
+	res.Storage = make(map[common.Address]map[common.Hash]common.Hash, len(t.slots))
+	for addr, slots := range t.slots {
+		values := make(map[common.Hash]common.Hash, len(slots))
+		for slot := range slots {
+			values[slot] = t.db.GetState(addr, slot)
+		}
+		res.Storage[addr] = values
+	}
+}
+
+// callEdgeTracer records the edges of the call graph of a call, from the top
+// level call down to every CALL- and CREATE-family operation executed.
+type callEdgeTracer struct {
+	unique bool // Whether to drop repeated edges
+	edges  []CallEdgeArgs
+	seen   map[CallEdgeArgs]struct{}
+}
+
+func newCallEdgeTracer(unique bool) *callEdgeTracer {
+	return &callEdgeTracer{unique: unique, edges: []CallEdgeArgs{}, seen: make(map[CallEdgeArgs]struct{})}
+}
+
+// add records an edge, unless it is a repeated one which are to be dropped.
+func (t *callEdgeTracer) add(from, to common.Address, op vm.OpCode) {
+	edge := CallEdgeArgs{From: from, To: to, Type: op.String()}
+	if t.unique {
+		if _, ok := t.seen[edge]; ok {
+			return
+		}
+		t.seen[edge] = struct{}{}
+	}
+	t.edges = append(t.edges, edge)
+}
+
+func (t *callEdgeTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	if create {
+		t.add(from, to, vm.CREATE)
+	} else {
+		t.add(from, to, vm.CALL)
+	}
+	return nil
+}
+
+func (t *callEdgeTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if err != nil {
+		return nil
+	}
+	caller := contract.Address()
+	switch op {
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		t.add(caller, common.BigToAddress(stack.Back(1)), op)
+
+	case vm.CREATE:
+		t.add(caller, crypto.CreateAddress(caller, env.StateDB.GetNonce(caller)), op)
+
+	case vm.CREATE2:
+		code := memory.GetPtr(stack.Back(1).Int64(), stack.Back(2).Int64())
+		t.add(caller, crypto.CreateAddress2(caller, common.BigToHash(stack.Back(3)), crypto.Keccak256(code)), op)
+	}
+	return nil
+}
+
+func (t *callEdgeTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *callEdgeTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+func (t *callEdgeTracer) report(res *ExecutionResultArgs) {
+	res.CallEdges = t.edges
+}
+
+// initCodeTracer records the init code of the contract creations of a call.
+type initCodeTracer struct {
+	inits []InitCodeArgs
+}
+
+func newInitCodeTracer() *initCodeTracer {
+	return &initCodeTracer{inits: []InitCodeArgs{}}
+}
+
+func (t *initCodeTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	if create {
+		t.inits = append(t.inits, newInitCodeArgs(vm.CREATE, 1, uint64(len(input))))
+	}
+	return nil
+}
+
+func (t *initCodeTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	// The init code was placed in memory if the operation was paid for
+	if err == nil && (op == vm.CREATE || op == vm.CREATE2) {
+		t.inits = append(t.inits, newInitCodeArgs(op, depth+1, stack.Back(2).Uint64()))
+	}
+	return nil
+}
+
+func (t *initCodeTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *initCodeTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+func (t *initCodeTracer) report(res *ExecutionResultArgs) {
+	res.InitCodes = t.inits
+}
+
+// codeIntrospectionTracer records the accounts whose code a call inspects without
+// calling them.
+type codeIntrospectionTracer struct {
+	inspections []CodeIntrospectionArgs
+	seen        map[CodeIntrospectionArgs]struct{}
+}
+
+func newCodeIntrospectionTracer() *codeIntrospectionTracer {
+	return &codeIntrospectionTracer{inspections: []CodeIntrospectionArgs{}, seen: make(map[CodeIntrospectionArgs]struct{})}
+}
+
+func (t *codeIntrospectionTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+func (t *codeIntrospectionTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if err != nil {
+		return nil
+	}
+	switch op {
+	case vm.EXTCODESIZE, vm.EXTCODEHASH, vm.EXTCODECOPY:
+		inspection := CodeIntrospectionArgs{Address: common.BigToAddress(stack.Back(0)), Op: op.String()}
+		if _, ok := t.seen[inspection]; !ok {
+			t.seen[inspection] = struct{}{}
+			t.inspections = append(t.inspections, inspection)
+		}
+	}
+	return nil
+}
+
+func (t *codeIntrospectionTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *codeIntrospectionTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+func (t *codeIntrospectionTracer) report(res *ExecutionResultArgs) {
+	res.CodeIntrospections = t.inspections
+}
+
+// reentrancyTracer records the calls into contracts which are already executing
+// further up the call stack. Frames are identified by the contract whose storage
+// they operate on, so delegated frames count as frames of their caller.
+type reentrancyTracer struct {
+	stack     []common.Address // Contracts executing at every depth, outermost first
+	reentries []ReentryArgs
+}
+
+func newReentrancyTracer() *reentrancyTracer {
+	return &reentrancyTracer{reentries: []ReentryArgs{}}
+}
+
+func (t *reentrancyTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+func (t *reentrancyTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	// Drop the frames which returned and record the executing one
+	if len(t.stack) >= depth {
+		t.stack = t.stack[:depth-1]
+	}
+	t.stack = append(t.stack, contract.Address())
+
+	if err != nil {
+		return nil
+	}
+	switch op {
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		to := common.BigToAddress(stack.Back(1))
+		for i, addr := range t.stack {
+			if addr == to {
+				t.reentries = append(t.reentries, ReentryArgs{
+					Address:    to,
+					Op:         op.String(),
+					Depth:      depth + 1,
+					OuterDepth: i + 1,
+					Delegate:   op == vm.DELEGATECALL || op == vm.CALLCODE,
+				})
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (t *reentrancyTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *reentrancyTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+func (t *reentrancyTracer) report(res *ExecutionResultArgs) {
+	res.Reentries = t.reentries
+}
+
+// valueTracer sums up the value a call transfers, by the call itself and by the
+// operations moving value to other accounts. Only the value moved by frames
+// which succeed is counted, value moved by reverted frames never left.
+type valueTracer struct {
+	value *big.Int
+
+	frames  []*big.Int // Value moved by every active frame, or by its callees
+	pending *big.Int   // Value moved by the call or creation being entered
+}
+
+func newValueTracer() *valueTracer {
+	return &valueTracer{value: new(big.Int)}
+}
+
+func (t *valueTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.pending = new(big.Int).Set(value)
+	return nil
+}
+
+func (t *valueTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	// Resuming a frame means its callee has returned, with its outcome on top
+	// of the stack. Only failed callees push a zero, either as the status of a
+	// call or as the address of a creation. Callees without code, such as
+	// plain transfers and precompiles, don't have a frame of their own.
+	for len(t.frames) > depth {
+		if callee := t.frames[len(t.frames)-1]; stack.Back(0).Sign() != 0 {
+			t.frames[len(t.frames)-2].Add(t.frames[len(t.frames)-2], callee)
+		}
+		t.frames = t.frames[:len(t.frames)-1]
+	}
+	if t.pending != nil && len(t.frames) == depth {
+		if stack.Back(0).Sign() != 0 {
+			t.frames[depth-1].Add(t.frames[depth-1], t.pending)
+		}
+		t.pending = nil
+	}
+	for len(t.frames) < depth {
+		value := new(big.Int)
+		if t.pending != nil {
+			value, t.pending = t.pending, nil
+		}
+		t.frames = append(t.frames, value)
+	}
+	if err != nil {
+		return nil
+	}
+	switch op {
+	case vm.CALL:
+		t.pending = new(big.Int).Set(stack.Back(2))
+	case vm.CREATE, vm.CREATE2:
+		t.pending = new(big.Int).Set(stack.Back(0))
+	case vm.SELFDESTRUCT:
+		t.frames[depth-1].Add(t.frames[depth-1], env.StateDB.GetBalance(contract.Address()))
+	}
+	return nil
+}
+
+func (t *valueTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *valueTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	switch {
+	case err != nil:
+		// The call failed, so none of its transfers took effect
+	case len(t.frames) > 0:
+		t.value.Set(t.frames[0])
+	case t.pending != nil:
+		// The call didn't execute any code, it only transferred its value
+		t.value.Set(t.pending)
+	}
+	return nil
+}
+
+func (t *valueTracer) report(res *ExecutionResultArgs) {
+	res.ValueTransferred = (*hexutil.Big)(t.value)
+}
+
+// outOfGasTracer records the operation a call ran out of gas at. Frames running
+// out of gas abort before the operation is executed, so they report it as a
+// state capture carrying the error.
+type outOfGasTracer struct {
+	last   *OutOfGasArgs // Latest operation any frame ran out of gas at
+	failed bool          // Whether the call itself ran out of gas
+}
+
+func newOutOfGasTracer() *outOfGasTracer {
+	return new(outOfGasTracer)
+}
+
+func (t *outOfGasTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+func (t *outOfGasTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if err == vm.ErrOutOfGas {
+		t.last = &OutOfGasArgs{
+			Address: contract.Address(),
+			Depth:   depth,
+			PC:      hexutil.Uint64(pc),
+			Op:      op.String(),
+			Gas:     hexutil.Uint64(gas),
+			Cost:    hexutil.Uint64(cost),
+		}
+	}
+	return nil
+}
+
+func (t *outOfGasTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *outOfGasTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	t.failed = err == vm.ErrOutOfGas
+	return nil
+}
+
+// report sets the location the call ran out of gas at. Frames running out of
+// gas within calls which recovered from it aren't reported.
+func (t *outOfGasTracer) report(res *ExecutionResultArgs) {
+	if t.failed {
+		res.OutOfGasAt = t.last
+	}
+}
+
+// peakOpTracer records the operation of a call which charged the most gas. The
+// cost of CALL-family operations includes the gas forwarded to the callee, which
+// is charged by the operations of the callee instead.
+type peakOpTracer struct {
+	peak *PeakOpArgs
+}
+
+func newPeakOpTracer() *peakOpTracer {
+	return new(peakOpTracer)
+}
+
+func (t *peakOpTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+func (t *peakOpTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	// Operations which couldn't be paid for aren't executed
+	if err != nil {
+		return nil
+	}
+	switch op {
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		cost -= env.CallGas()
+	}
+	if t.peak == nil || cost > uint64(t.peak.Gas) {
+		t.peak = &PeakOpArgs{
+			Address: contract.Address(),
+			Depth:   depth,
+			PC:      hexutil.Uint64(pc),
+			Op:      op.String(),
+			Gas:     hexutil.Uint64(cost),
+		}
+	}
+	return nil
+}
+
+func (t *peakOpTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *peakOpTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+func (t *peakOpTracer) report(res *ExecutionResultArgs) {
+	res.PeakOp = t.peak
+}
+
+// backwardJumpTracer counts the jumps to lower program counters taken by a
+// call, which every loop executes once per iteration.
+type backwardJumpTracer struct {
+	threshold uint64 // Number of backward jumps beyond which a loop is suspected, 0 if none
+	jumps     uint64
+}
+
+func newBackwardJumpTracer(threshold uint64) *backwardJumpTracer {
+	return &backwardJumpTracer{threshold: threshold}
+}
+
+func (t *backwardJumpTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	return nil
+}
+
+func (t *backwardJumpTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	if err != nil {
+		return nil
+	}
+	switch op {
+	case vm.JUMPI:
+		if stack.Back(1).Sign() == 0 {
+			return nil
+		}
+		fallthrough
+	case vm.JUMP:
+		if dest := stack.Back(0); dest.IsUint64() && dest.Uint64() < pc {
+			t.jumps++
+		}
+	}
+	return nil
+}
+
+func (t *backwardJumpTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *backwardJumpTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+func (t *backwardJumpTracer) report(res *ExecutionResultArgs) {
+	jumps := hexutil.Uint64(t.jumps)
+	res.BackwardJumps = &jumps
+	res.LoopSuspected = t.threshold > 0 && t.jumps > t.threshold
+}
@@ -0,0 +1,195 @@
+// Copyright 2019 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package ethapi
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// StateWitness is the part of the state a multicall batch accessed, as it was
+// before the batch. Executing the batch on a state consisting of the witness
+// only reproduces the results of the batch.
+type StateWitness struct {
+	// Accounts contains the accounts accessed along with their code and the
+	// storage slots accessed. Accounts which didn't exist are mapped to nil.
+	Accounts map[common.Address]*WitnessAccountArgs `json:"accounts"`
+
+	// Root is the state root the proofs are against, if the batch was executed
+	// on the unmodified state of a block. The state of a block can't be proven
+	// once it was overridden or transactions were replayed on top of it.
+	Root   *common.Hash                      `json:"root,omitempty"`
+	Proofs map[common.Address]*AccountResult `json:"proofs,omitempty"`
+}
+
+// WitnessAccountArgs is the state of an account within a StateWitness.
+type WitnessAccountArgs struct {
+	Balance *hexutil.Big                `json:"balance"`
+	Nonce   hexutil.Uint64              `json:"nonce"`
+	Code    hexutil.Bytes               `json:"code"`
+	Storage map[common.Hash]common.Hash `json:"storage"`
+}
+
+// witnessStateDB records the accounts and storage slots accessed through it, to
+// collect them from a copy of the state taken before any access.
+type witnessStateDB struct {
+	vm.StateDB
+	pre      *state.StateDB // State before the accesses
+	accessed map[common.Address]map[common.Hash]struct{}
+}
+
+func newWitnessStateDB(db vm.StateDB, pre *state.StateDB) *witnessStateDB {
+	return &witnessStateDB{StateDB: db, pre: pre, accessed: make(map[common.Address]map[common.Hash]struct{})}
+}
+
+// touch records an access of the account.
+func (db *witnessStateDB) touch(addr common.Address) {
+	if db.accessed[addr] == nil {
+		db.accessed[addr] = make(map[common.Hash]struct{})
+	}
+}
+
+// touchSlot records an access of the storage slot.
+func (db *witnessStateDB) touchSlot(addr common.Address, key common.Hash) {
+	db.touch(addr)
+	db.accessed[addr][key] = struct{}{}
+}
+
+func (db *witnessStateDB) CreateAccount(addr common.Address) {
+	db.touch(addr)
+	db.StateDB.CreateAccount(addr)
+}
+
+func (db *witnessStateDB) SubBalance(addr common.Address, amount *big.Int) {
+	db.touch(addr)
+	db.StateDB.SubBalance(addr, amount)
+}
+
+func (db *witnessStateDB) AddBalance(addr common.Address, amount *big.Int) {
+	db.touch(addr)
+	db.StateDB.AddBalance(addr, amount)
+}
+
+func (db *witnessStateDB) GetBalance(addr common.Address) *big.Int {
+	db.touch(addr)
+	return db.StateDB.GetBalance(addr)
+}
+
+func (db *witnessStateDB) GetNonce(addr common.Address) uint64 {
+	db.touch(addr)
+	return db.StateDB.GetNonce(addr)
+}
+
+func (db *witnessStateDB) SetNonce(addr common.Address, nonce uint64) {
+	db.touch(addr)
+	db.StateDB.SetNonce(addr, nonce)
+}
+
+func (db *witnessStateDB) GetCodeHash(addr common.Address) common.Hash {
+	db.touch(addr)
+	return db.StateDB.GetCodeHash(addr)
+}
+
+func (db *witnessStateDB) GetCode(addr common.Address) []byte {
+	db.touch(addr)
+	return db.StateDB.GetCode(addr)
+}
+
+func (db *witnessStateDB) SetCode(addr common.Address, code []byte) {
+	db.touch(addr)
+	db.StateDB.SetCode(addr, code)
+}
+
+func (db *witnessStateDB) GetCodeSize(addr common.Address) int {
+	db.touch(addr)
+	return db.StateDB.GetCodeSize(addr)
+}
+
+func (db *witnessStateDB) GetCommittedState(addr common.Address, key common.Hash) common.Hash {
+	db.touchSlot(addr, key)
+	return db.StateDB.GetCommittedState(addr, key)
+}
+
+func (db *witnessStateDB) GetState(addr common.Address, key common.Hash) common.Hash {
+	db.touchSlot(addr, key)
+	return db.StateDB.GetState(addr, key)
+}
+
+func (db *witnessStateDB) SetState(addr common.Address, key, value common.Hash) {
+	db.touchSlot(addr, key)
+	db.StateDB.SetState(addr, key, value)
+}
+
+func (db *witnessStateDB) Suicide(addr common.Address) bool {
+	db.touch(addr)
+	return db.StateDB.Suicide(addr)
+}
+
+func (db *witnessStateDB) HasSuicided(addr common.Address) bool {
+	db.touch(addr)
+	return db.StateDB.HasSuicided(addr)
+}
+
+func (db *witnessStateDB) Exist(addr common.Address) bool {
+	db.touch(addr)
+	return db.StateDB.Exist(addr)
+}
+
+func (db *witnessStateDB) Empty(addr common.Address) bool {
+	db.touch(addr)
+	return db.StateDB.Empty(addr)
+}
+
+// witness collects the accessed part of the state before the accesses. Proofs
+// against the given root are added if prove is set.
+func (db *witnessStateDB) witness(root common.Hash, prove bool) (*StateWitness, error) {
+	pre := db.pre
+	w := &StateWitness{Accounts: make(map[common.Address]*WitnessAccountArgs, len(db.accessed))}
+	if prove {
+		w.Root, w.Proofs = &root, make(map[common.Address]*AccountResult, len(db.accessed))
+	}
+	for addr, slots := range db.accessed {
+		var keys []string
+		if pre.Exist(addr) {
+			account := &WitnessAccountArgs{
+				Balance: (*hexutil.Big)(pre.GetBalance(addr)),
+				Nonce:   hexutil.Uint64(pre.GetNonce(addr)),
+				Code:    pre.GetCode(addr),
+				Storage: make(map[common.Hash]common.Hash, len(slots)),
+			}
+			for key := range slots {
+				account.Storage[key] = pre.GetState(addr, key)
+				keys = append(keys, key.Hex())
+			}
+			w.Accounts[addr] = account
+		} else {
+			w.Accounts[addr] = nil
+		}
+		if prove {
+			proof, err := proveAccount(pre, addr, keys)
+			if err != nil {
+				return nil, err
+			}
+			w.Proofs[addr] = proof
+		}
+	}
+	return w, nil
+}
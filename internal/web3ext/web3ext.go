@@ -522,6 +522,12 @@ web3._extend({
 			params: 3,
 			inputFormatter: [web3._extend.formatters.inputAddressFormatter, null, web3._extend.formatters.inputBlockNumberFormatter]
 		}),
+		new web3._extend.Method({
+			name: 'multicall',
+			call: 'eth_multicall',
+			params: 4,
+			inputFormatter: [null, web3._extend.formatters.inputBlockNumberFormatter, null, null]
+		}),
 	],
 	properties: [
 		new web3._extend.Property({